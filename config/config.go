@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -45,6 +46,24 @@ const (
 	AuthTypeDefault = AuthTypeOIDC
 )
 
+// Random image delivery modes
+const (
+	// RandomModeProxy streams the chosen image through the server
+	RandomModeProxy = "proxy"
+	// RandomModeRedirect answers with a redirect to the image's public or CDN URL
+	RandomModeRedirect = "redirect"
+)
+
+// Metadata stripping policies applied to uploaded images
+const (
+	// MetadataStripGPS removes the GPS location and keeps other camera data
+	MetadataStripGPS = "gps"
+	// MetadataStripAll removes all EXIF and XMP metadata
+	MetadataStripAll = "all"
+	// MetadataStripNone stores images as uploaded
+	MetadataStripNone = "none"
+)
+
 // Config stores the application configuration
 type Config struct {
 	// Server settings
@@ -60,6 +79,25 @@ type Config struct {
 	DebugMode       bool   `json:"debug_mode"`       // Whether debug mode is enabled
 	CleanupInterval int    `json:"cleanup_interval"` // Interval in minutes for cleaning expired images
 
+	// Derived variant settings
+	VariantCacheMaxMB int   `json:"variant_cache_max_mb"` // Size cap in MB for cached transform variants (0 disables caching)
+	ThumbnailSizes    []int `json:"thumbnail_sizes"`      // Long-edge sizes in pixels of thumbnails generated at upload
+
+	// Metadata privacy settings
+	MetadataStrip string `json:"metadata_strip"` // Metadata removed from uploads and their conversions: gps, all or none
+
+	// Remote and resumable upload settings
+	RemoteUploadMaxMB     int      `json:"remote_upload_max_mb"`    // Maximum size in MB of an image downloaded from a remote URL
+	RemoteUploadTimeout   int      `json:"remote_upload_timeout"`   // Timeout in seconds for downloading a remote image
+	RemoteUploadAllowlist []string `json:"remote_upload_allowlist"` // Hosts, IPs or CIDRs that may be fetched even if they are private
+	ChunkedUploadMaxMB    int      `json:"chunked_upload_max_mb"`   // Maximum total size in MB of a resumable chunked upload
+
+	// Random image settings
+	RandomMode string `json:"random_mode"` // Default delivery of /api/random: proxy or redirect
+
+	// Orientation settings
+	SquareTolerance float64 `json:"square_tolerance"` // Largest difference of the sides, relative to the longer side, of a square image
+
 	// Authentication settings
 	AuthType AuthType `json:"auth_type"` // Type of authentication to use
 
@@ -70,6 +108,7 @@ type Config struct {
 	OIDCRedirectURL  string   `json:"oidc_redirect_url"` // OIDC redirect URL
 	OIDCScopes       []string `json:"oidc_scopes"`       // OIDC scopes to request
 	JWTSigningKey    string   `json:"-"`                 // JWT signing key for session tokens
+	URLSigningKey    string   `json:"-"`                 // HMAC key for signed image URLs (falls back to JWTSigningKey)
 
 	// Storage settings
 	StorageType  StorageType `json:"storage_type"`  // Type of storage backend to use
@@ -140,6 +179,24 @@ func Load() (*Config, error) {
 		DebugMode:       false,              // Default debug mode off
 		CleanupInterval: 1,                  // Default cleanup interval: 1 minute
 
+		// Derived variant defaults
+		VariantCacheMaxMB: 1024,                 // Default variant cache cap: 1 GB
+		ThumbnailSizes:    []int{150, 400, 800}, // Default thumbnail sizes
+
+		// Metadata privacy defaults
+		MetadataStrip: MetadataStripGPS, // Default to removing locations, which images must not leak publicly
+
+		// Remote and resumable upload defaults
+		RemoteUploadMaxMB:   32,  // Default remote image size cap: 32 MB, same as multipart uploads
+		RemoteUploadTimeout: 30,  // Default remote download timeout: 30 seconds
+		ChunkedUploadMaxMB:  256, // Default resumable upload cap: 256 MB
+
+		// Random image defaults
+		RandomMode: RandomModeProxy, // Default to streaming random images through the server
+
+		// Orientation defaults
+		SquareTolerance: 0.02, // Default to sides within 2% of each other
+
 		// Auth defaults
 		AuthType: AuthTypeDefault, // Default to OIDC auth
 
@@ -191,9 +248,19 @@ func Load() (*Config, error) {
 		}
 	}
 
+	// Each size names a single stored thumbnail, so repeated sizes are generated once
+	cfg.ThumbnailSizes = normalizeThumbnailSizes(cfg.ThumbnailSizes)
+
 	return cfg, nil
 }
 
+// normalizeThumbnailSizes sorts the thumbnail sizes and drops repeated and non-positive ones
+func normalizeThumbnailSizes(sizes []int) []int {
+	sizes = slices.DeleteFunc(slices.Clone(sizes), func(size int) bool { return size <= 0 })
+	slices.Sort(sizes)
+	return slices.Compact(sizes)
+}
+
 // loadEnvVars loads configuration from environment variables
 func (c *Config) loadEnvVars() {
 	// Server settings
@@ -237,6 +304,10 @@ func (c *Config) loadEnvVars() {
 		}
 	}
 	c.JWTSigningKey = os.Getenv("JWT_SIGNING_KEY")
+	c.URLSigningKey = os.Getenv("URL_SIGNING_KEY")
+	if c.URLSigningKey == "" {
+		c.URLSigningKey = c.JWTSigningKey
+	}
 
 	// Storage settings
 	if storageType := os.Getenv("STORAGE_TYPE"); storageType != "" {
@@ -259,13 +330,17 @@ func (c *Config) loadEnvVars() {
 
 	// Parse integer environment variables
 	envVarInt := map[string]*int{
-		"MAX_UPLOAD_COUNT": &c.MaxUploadCount,
-		"IMAGE_QUALITY":    &c.ImageQuality,
-		"WORKER_THREADS":   &c.WorkerThreads,
-		"SPEED":            &c.Speed,
-		"WORKER_POOL_SIZE": &c.WorkerPoolSize,
-		"REDIS_DB":         &c.RedisDB,
-		"CLEANUP_INTERVAL": &c.CleanupInterval,
+		"MAX_UPLOAD_COUNT":      &c.MaxUploadCount,
+		"IMAGE_QUALITY":         &c.ImageQuality,
+		"WORKER_THREADS":        &c.WorkerThreads,
+		"SPEED":                 &c.Speed,
+		"WORKER_POOL_SIZE":      &c.WorkerPoolSize,
+		"REDIS_DB":              &c.RedisDB,
+		"CLEANUP_INTERVAL":      &c.CleanupInterval,
+		"VARIANT_CACHE_MAX_MB":  &c.VariantCacheMaxMB,
+		"REMOTE_UPLOAD_MAX_MB":  &c.RemoteUploadMaxMB,
+		"REMOTE_UPLOAD_TIMEOUT": &c.RemoteUploadTimeout,
+		"CHUNKED_UPLOAD_MAX_MB": &c.ChunkedUploadMaxMB,
 	}
 
 	for envName, ptr := range envVarInt {
@@ -276,6 +351,57 @@ func (c *Config) loadEnvVars() {
 		}
 	}
 
+	// Thumbnail sizes
+	if sizes := os.Getenv("THUMBNAIL_SIZES"); sizes != "" {
+		c.ThumbnailSizes = nil
+		for _, size := range strings.Split(sizes, ",") {
+			if num, err := strconv.Atoi(strings.TrimSpace(size)); err == nil && num > 0 {
+				c.ThumbnailSizes = append(c.ThumbnailSizes, num)
+			}
+		}
+	}
+
+	// Remote upload allowlist
+	if allowlist := os.Getenv("REMOTE_UPLOAD_ALLOWLIST"); allowlist != "" {
+		c.RemoteUploadAllowlist = nil
+		for _, entry := range strings.Split(allowlist, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				c.RemoteUploadAllowlist = append(c.RemoteUploadAllowlist, entry)
+			}
+		}
+	}
+
+	// Metadata stripping policy
+	if policy := os.Getenv("METADATA_STRIP"); policy != "" {
+		switch policy {
+		case MetadataStripGPS, MetadataStripAll, MetadataStripNone:
+			c.MetadataStrip = policy
+		default:
+			fmt.Printf("Warning: Invalid metadata strip policy specified (%s), using %s\n", policy, MetadataStripGPS)
+			c.MetadataStrip = MetadataStripGPS
+		}
+	}
+
+	// Random image delivery mode
+	if mode := os.Getenv("RANDOM_MODE"); mode != "" {
+		switch mode {
+		case RandomModeProxy, RandomModeRedirect:
+			c.RandomMode = mode
+		default:
+			fmt.Printf("Warning: Invalid random mode specified (%s), using %s\n", mode, RandomModeProxy)
+			c.RandomMode = RandomModeProxy
+		}
+	}
+
+	// Square orientation tolerance
+	if tolerance := os.Getenv("SQUARE_TOLERANCE"); tolerance != "" {
+		if value, err := strconv.ParseFloat(tolerance, 64); err == nil && value >= 0 && value < 1 {
+			c.SquareTolerance = value
+		} else {
+			fmt.Printf("Warning: Invalid square tolerance specified (%s), using %g\n", tolerance, c.SquareTolerance)
+		}
+	}
+
 	// Ensure speed is within valid range (0-8)
 	if c.Speed < 0 {
 		c.Speed = 0
@@ -31,6 +31,7 @@ export interface ImageFile {
     original: string;
     webp: string;
     avif: string;
+    [thumbnail: string]: string; // thumb_150, thumb_400, ...
   };
 }
 
@@ -83,6 +84,7 @@ export interface UploadResult {
     original: string;
     webp: string;
     avif: string;
+    [thumbnail: string]: string; // thumb_150, thumb_400, ...
   };
   id?: string;
   path?: string;
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Yuri-NagaSaki/ImageFlow/config"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils/errors"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils/logger"
+	"go.uber.org/zap"
+)
+
+// Batch operations
+const (
+	BatchDelete      = "delete"
+	BatchAddTags     = "add_tags"
+	BatchRemoveTags  = "remove_tags"
+	BatchSetExpiry   = "set_expiry"
+	BatchClearExpiry = "clear_expiry"
+)
+
+// maxBatchSize caps the number of images handled by a single batch request
+const maxBatchSize = 500
+
+// BatchRequest represents the request body for a batch operation
+type BatchRequest struct {
+	IDs        []string `json:"ids"`        // Image IDs to operate on
+	Operation  string   `json:"operation"`  // delete, add_tags, remove_tags, set_expiry or clear_expiry
+	Tags       []string `json:"tags"`       // Tags for add_tags and remove_tags
+	ExpiryTime string   `json:"expiryTime"` // Expiry timestamp (RFC3339) for set_expiry
+}
+
+// BatchResult represents the outcome of a batch operation for a single image
+type BatchResult struct {
+	ID      string `json:"id"`      // Image ID
+	Success bool   `json:"success"` // Whether the operation succeeded for this image
+	Message string `json:"message"` // Description of the result
+}
+
+// BatchResponse represents the response of a batch operation
+type BatchResponse struct {
+	Success   bool          `json:"success"`   // Whether the operation succeeded for every image
+	Succeeded int           `json:"succeeded"` // Number of images processed successfully
+	Failed    int           `json:"failed"`    // Number of images that failed
+	Results   []BatchResult `json:"results"`   // Per-image results in request order
+}
+
+// BatchHandler applies one operation to many images in a single request
+func BatchHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			errors.HandleError(w, errors.ErrInvalidParam, "Method not allowed", nil)
+			logger.Warn("Invalid request method",
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path))
+			return
+		}
+
+		// Get user from context (set by RequireAuth middleware)
+		user, ok := GetUserFromContext(r.Context())
+		if !ok {
+			errors.HandleError(w, errors.ErrUnauthorized, "Authentication required", nil)
+			return
+		}
+
+		var req BatchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			errors.HandleError(w, errors.ErrInvalidParam, "Invalid request body", nil)
+			logger.Warn("Failed to decode request body",
+				zap.Error(err))
+			return
+		}
+
+		if len(req.IDs) == 0 {
+			errors.HandleError(w, errors.ErrInvalidParam, "At least one image ID is required", nil)
+			return
+		}
+		if len(req.IDs) > maxBatchSize {
+			errors.HandleError(w, errors.ErrInvalidParam,
+				fmt.Sprintf("A batch may contain at most %d images", maxBatchSize), nil)
+			return
+		}
+
+		// Validate operation arguments once for the whole batch, using the same
+		// rules as single image updates
+		var update UpdateImageRequest
+		switch req.Operation {
+		case BatchDelete, BatchClearExpiry:
+		case BatchAddTags, BatchRemoveTags:
+			req.Tags = utils.NormalizeTags(req.Tags)
+			if len(req.Tags) == 0 {
+				errors.HandleError(w, errors.ErrInvalidParam, "At least one tag is required", nil)
+				return
+			}
+			update.Tags = &req.Tags
+		case BatchSetExpiry:
+			if req.ExpiryTime == "" {
+				errors.HandleError(w, errors.ErrInvalidParam, "expiryTime is required", nil)
+				return
+			}
+			update.ExpiryTime = &req.ExpiryTime
+		default:
+			errors.HandleError(w, errors.ErrInvalidParam, "Unsupported batch operation", nil)
+			return
+		}
+		if err := applyMetadataUpdate(&utils.ImageMetadata{}, &update); err != nil {
+			errors.HandleError(w, errors.ErrInvalidParam, err.Error(), nil)
+			return
+		}
+
+		logger.Info("Processing batch request",
+			zap.String("operation", req.Operation),
+			zap.Int("count", len(req.IDs)),
+			zap.String("user_id", user.ID))
+
+		resp := BatchResponse{Results: make([]BatchResult, 0, len(req.IDs))}
+		var deleted []string
+		for _, id := range req.IDs {
+			result := BatchResult{ID: id}
+			result.Success, result.Message = applyBatchOperation(r.Context(), id, &req, user, cfg)
+			if result.Success {
+				resp.Succeeded++
+				if req.Operation == BatchDelete {
+					deleted = append(deleted, id)
+				}
+			} else {
+				resp.Failed++
+			}
+			resp.Results = append(resp.Results, result)
+		}
+		resp.Success = resp.Failed == 0
+
+		// Collections are scanned once for all deleted images
+		if err := utils.RemoveFromCollections(r.Context(), deleted); err != nil {
+			logger.Warn("Failed to remove deleted images from collections", zap.Error(err))
+		}
+
+		// Saved metadata already cleared the page cache, but deletions do not, so clear
+		// it once for all deleted images
+		if len(deleted) > 0 {
+			if err := utils.ClearPageCache(r.Context()); err != nil {
+				logger.Warn("Failed to clear page cache", zap.Error(err))
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logger.Error("Failed to encode response", zap.Error(err))
+		}
+
+		logger.Info("Batch operation completed",
+			zap.String("operation", req.Operation),
+			zap.Int("succeeded", resp.Succeeded),
+			zap.Int("failed", resp.Failed))
+	}
+}
+
+// applyBatchOperation runs the batch operation on a single image
+func applyBatchOperation(ctx context.Context, id string, req *BatchRequest, user *utils.User, cfg *config.Config) (bool, string) {
+	if id == "" {
+		return false, "Image ID is required"
+	}
+
+	// Verify image ownership (except for API key users)
+	if cfg.AuthType == config.AuthTypeOIDC && user.ID != "api_key_user" {
+		if err := utils.MetadataManager.VerifyImageOwnership(ctx, id, user.ID); err != nil {
+			logger.Warn("User attempted batch operation on image they don't own",
+				zap.String("user_id", user.ID),
+				zap.String("image_id", id),
+				zap.Error(err))
+			return false, "You don't have permission to modify this image"
+		}
+	}
+
+	if req.Operation == BatchDelete {
+		return deleteImage(ctx, id, cfg)
+	}
+
+	metadata, err := utils.MetadataManager.GetMetadata(ctx, id)
+	if err != nil {
+		return false, "Image not found"
+	}
+
+	switch req.Operation {
+	case BatchAddTags:
+		metadata.Tags = utils.NormalizeTags(append(metadata.Tags, req.Tags...))
+	case BatchRemoveTags:
+		remove := make(map[string]bool, len(req.Tags))
+		for _, tag := range req.Tags {
+			remove[tag] = true
+		}
+		kept := make([]string, 0, len(metadata.Tags))
+		for _, tag := range metadata.Tags {
+			if !remove[tag] {
+				kept = append(kept, tag)
+			}
+		}
+		metadata.Tags = kept
+	case BatchSetExpiry:
+		metadata.ExpiryTime, _ = time.Parse(time.RFC3339, req.ExpiryTime)
+	case BatchClearExpiry:
+		metadata.ExpiryTime = time.Time{}
+	}
+
+	if err := utils.MetadataManager.SaveMetadata(ctx, metadata); err != nil {
+		logger.Error("Failed to save metadata in batch operation",
+			zap.String("image_id", id),
+			zap.String("operation", req.Operation),
+			zap.Error(err))
+		return false, "Failed to save image metadata"
+	}
+
+	return true, "Image updated successfully"
+}
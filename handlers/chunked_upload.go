@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Yuri-NagaSaki/ImageFlow/config"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils/errors"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils/logger"
+	"go.uber.org/zap"
+)
+
+// ChunkedUploadRequest represents the request body for starting a resumable upload
+type ChunkedUploadRequest struct {
+	Filename      string   `json:"filename"`      // Original filename
+	Size          int64    `json:"size"`          // Total size in bytes
+	ChunkSize     int64    `json:"chunkSize"`     // Chunk size in bytes (default 5 MB, 256 KB to 32 MB)
+	Tags          []string `json:"tags"`          // Tags applied to the image
+	ExpiryMinutes int      `json:"expiryMinutes"` // Minutes until the image expires (0 = never)
+	Visibility    string   `json:"visibility"`    // public, unlisted or private (default public)
+	MergeTags     bool     `json:"mergeTags"`     // Merge tags into an identical image already uploaded
+}
+
+// ChunkedUploadStatus represents the progress of a resumable upload
+type ChunkedUploadStatus struct {
+	Success bool `json:"success"` // Whether the request was successful
+	*utils.UploadSession
+	ReceivedChunks int   `json:"receivedChunks"` // Number of chunks received so far
+	MissingChunks  []int `json:"missingChunks"`  // Indexes of the chunks still to be sent
+}
+
+// requireUploadSession loads an upload session owned by the user, writing an error response if there is none
+func requireUploadSession(w http.ResponseWriter, r *http.Request, user *utils.User) (*utils.UploadSession, bool) {
+	if !utils.IsRedisMetadataStore() {
+		errors.HandleError(w, errors.ErrInternal, "Redis is required for resumable uploads", nil)
+		return nil, false
+	}
+
+	id := r.PathValue("uploadId")
+	session, err := utils.GetUploadSession(r.Context(), id)
+	if err != nil || session.UserID != user.ID {
+		// Sessions of other users are reported as missing rather than forbidden
+		errors.HandleError(w, errors.ErrNotFound, "Upload not found or expired", nil)
+		logger.Debug("Upload session lookup failed",
+			zap.String("upload_id", id),
+			zap.String("user_id", user.ID),
+			zap.Error(err))
+		return nil, false
+	}
+	return session, true
+}
+
+// writeUploadStatus writes the progress of an upload session as JSON
+func writeUploadStatus(w http.ResponseWriter, r *http.Request, session *utils.UploadSession) {
+	received, err := utils.ReceivedChunks(r.Context(), session.ID)
+	if err != nil {
+		errors.HandleError(w, errors.ErrInternal, "Failed to read upload progress", nil)
+		logger.Error("Failed to read received chunks",
+			zap.String("upload_id", session.ID),
+			zap.Error(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ChunkedUploadStatus{
+		Success:        true,
+		UploadSession:  session,
+		ReceivedChunks: len(received),
+		MissingChunks:  session.MissingChunks(received),
+	}); err != nil {
+		logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+// CreateChunkedUploadHandler starts a resumable upload and returns its upload ID and chunk layout
+func CreateChunkedUploadHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			errors.HandleError(w, errors.ErrInvalidParam, "Method not allowed", nil)
+			return
+		}
+
+		// Get user from context (set by RequireAuth middleware)
+		user, ok := GetUserFromContext(r.Context())
+		if !ok {
+			errors.HandleError(w, errors.ErrUnauthorized, "Authentication required", nil)
+			return
+		}
+
+		if !utils.IsRedisMetadataStore() {
+			errors.HandleError(w, errors.ErrInternal, "Redis is required for resumable uploads", nil)
+			return
+		}
+
+		var req ChunkedUploadRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			errors.HandleError(w, errors.ErrInvalidParam, "Invalid request body", nil)
+			logger.Warn("Failed to decode request body",
+				zap.Error(err))
+			return
+		}
+
+		req.Filename = strings.TrimSpace(req.Filename)
+		if req.Filename == "" {
+			errors.HandleError(w, errors.ErrInvalidParam, "filename is required", nil)
+			return
+		}
+		maxSize := int64(cfg.ChunkedUploadMaxMB) << 20
+		if req.Size <= 0 || req.Size > maxSize {
+			errors.HandleError(w, errors.ErrInvalidParam,
+				fmt.Sprintf("size must be between 1 byte and %d MB", cfg.ChunkedUploadMaxMB), nil)
+			return
+		}
+		if req.ChunkSize == 0 {
+			req.ChunkSize = utils.DefaultChunkSize
+		}
+		if req.ChunkSize < utils.MinChunkSize || req.ChunkSize > utils.MaxChunkSize {
+			errors.HandleError(w, errors.ErrInvalidParam,
+				fmt.Sprintf("chunkSize must be between %d and %d bytes", utils.MinChunkSize, utils.MaxChunkSize), nil)
+			return
+		}
+		if req.ExpiryMinutes < 0 {
+			errors.HandleError(w, errors.ErrInvalidParam, "expiryMinutes must not be negative", nil)
+			return
+		}
+
+		// Validate tags with the same rules as image updates
+		var validated utils.ImageMetadata
+		if err := applyMetadataUpdate(&validated, &UpdateImageRequest{Tags: &req.Tags}); err != nil {
+			errors.HandleError(w, errors.ErrInvalidParam, err.Error(), nil)
+			return
+		}
+
+		visibility, err := utils.NormalizeVisibility(req.Visibility)
+		if err != nil {
+			errors.HandleError(w, errors.ErrInvalidParam, err.Error(), nil)
+			return
+		}
+
+		session := &utils.UploadSession{
+			UserID:        user.ID,
+			Filename:      req.Filename,
+			Size:          req.Size,
+			ChunkSize:     req.ChunkSize,
+			Tags:          validated.Tags,
+			ExpiryMinutes: req.ExpiryMinutes,
+			Visibility:    visibility,
+			MergeTags:     req.MergeTags,
+		}
+		if err := utils.CreateUploadSession(r.Context(), session); err != nil {
+			errors.HandleError(w, errors.ErrImageUpload, "Failed to start upload", nil)
+			logger.Error("Failed to create upload session",
+				zap.String("user_id", user.ID),
+				zap.Error(err))
+			return
+		}
+
+		logger.Info("Resumable upload started",
+			zap.String("upload_id", session.ID),
+			zap.String("user_id", user.ID),
+			zap.String("filename", session.Filename),
+			zap.Int64("size", session.Size),
+			zap.Int("chunks", session.TotalChunks))
+
+		writeUploadStatus(w, r, session)
+	}
+}
+
+// ChunkedUploadHandler reports the progress of a resumable upload (GET) or aborts it (DELETE)
+func ChunkedUploadHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+			errors.HandleError(w, errors.ErrInvalidParam, "Method not allowed", nil)
+			return
+		}
+
+		// Get user from context (set by RequireAuth middleware)
+		user, ok := GetUserFromContext(r.Context())
+		if !ok {
+			errors.HandleError(w, errors.ErrUnauthorized, "Authentication required", nil)
+			return
+		}
+
+		session, ok := requireUploadSession(w, r, user)
+		if !ok {
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			writeUploadStatus(w, r, session)
+			return
+		}
+
+		utils.DeleteUploadSession(r.Context(), session.ID, session.TotalChunks)
+		logger.Info("Resumable upload aborted",
+			zap.String("upload_id", session.ID),
+			zap.String("user_id", user.ID))
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "Upload aborted",
+		}); err != nil {
+			logger.Error("Failed to encode response", zap.Error(err))
+		}
+	}
+}
+
+// UploadChunkHandler stages one chunk of a resumable upload. Chunks may be sent in
+// any order and re-sent after a failure; the request body is the raw chunk data.
+func UploadChunkHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			errors.HandleError(w, errors.ErrInvalidParam, "Method not allowed", nil)
+			return
+		}
+
+		// Get user from context (set by RequireAuth middleware)
+		user, ok := GetUserFromContext(r.Context())
+		if !ok {
+			errors.HandleError(w, errors.ErrUnauthorized, "Authentication required", nil)
+			return
+		}
+
+		session, ok := requireUploadSession(w, r, user)
+		if !ok {
+			return
+		}
+
+		index, err := strconv.Atoi(r.PathValue("index"))
+		if err != nil || index < 0 || index >= session.TotalChunks {
+			errors.HandleError(w, errors.ErrInvalidParam,
+				fmt.Sprintf("Chunk index must be between 0 and %d", session.TotalChunks-1), nil)
+			return
+		}
+
+		expected := session.ChunkLength(index)
+		if r.ContentLength >= 0 && r.ContentLength != expected {
+			errors.HandleError(w, errors.ErrInvalidParam,
+				fmt.Sprintf("Chunk %d must be exactly %d bytes", index, expected), nil)
+			return
+		}
+
+		// Stream the chunk straight to storage; staged chunks are never publicly readable
+		key := utils.ChunkKey(session.ID, index)
+		if err := utils.PutImageObject(r.Context(), key, io.LimitReader(r.Body, expected), expected, "application/octet-stream", true); err != nil {
+			errors.HandleError(w, errors.ErrImageUpload, "Failed to store chunk", nil)
+			logger.Error("Failed to store chunk",
+				zap.String("upload_id", session.ID),
+				zap.Int("index", index),
+				zap.Error(err))
+			return
+		}
+		if err := utils.MarkChunkReceived(r.Context(), session, index); err != nil {
+			errors.HandleError(w, errors.ErrImageUpload, "Failed to record chunk", nil)
+			logger.Error("Failed to record chunk",
+				zap.String("upload_id", session.ID),
+				zap.Int("index", index),
+				zap.Error(err))
+			return
+		}
+
+		logger.Debug("Chunk received",
+			zap.String("upload_id", session.ID),
+			zap.Int("index", index),
+			zap.Int64("bytes", expected))
+
+		writeUploadStatus(w, r, session)
+	}
+}
+
+// CompleteChunkedUploadHandler assembles the chunks of a finished upload and runs
+// the image through the regular upload pipeline
+func CompleteChunkedUploadHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			errors.HandleError(w, errors.ErrInvalidParam, "Method not allowed", nil)
+			return
+		}
+
+		// Get user from context (set by RequireAuth middleware)
+		user, ok := GetUserFromContext(r.Context())
+		if !ok {
+			errors.HandleError(w, errors.ErrUnauthorized, "Authentication required", nil)
+			return
+		}
+
+		session, ok := requireUploadSession(w, r, user)
+		if !ok {
+			return
+		}
+
+		received, err := utils.ReceivedChunks(r.Context(), session.ID)
+		if err != nil {
+			errors.HandleError(w, errors.ErrInternal, "Failed to read upload progress", nil)
+			return
+		}
+		if missing := session.MissingChunks(received); len(missing) > 0 {
+			errors.HandleError(w, errors.ErrInvalidParam,
+				fmt.Sprintf("Upload is incomplete, %d chunks missing", len(missing)), nil)
+			return
+		}
+
+		claimed, err := utils.ClaimUploadCompletion(r.Context(), session.ID)
+		if err != nil {
+			errors.HandleError(w, errors.ErrInternal, "Failed to complete upload", nil)
+			logger.Error("Failed to claim upload completion",
+				zap.String("upload_id", session.ID),
+				zap.Error(err))
+			return
+		}
+		if !claimed {
+			errors.HandleError(w, errors.ErrInvalidParam, "Upload is already being completed", nil)
+			return
+		}
+
+		data, err := utils.AssembleUpload(r.Context(), session)
+		if err != nil {
+			utils.ReleaseUploadCompletion(r.Context(), session.ID)
+			errors.HandleError(w, errors.ErrImageUpload, "Failed to assemble upload", nil)
+			logger.Error("Failed to assemble chunks",
+				zap.String("upload_id", session.ID),
+				zap.Error(err))
+			return
+		}
+
+		var expiryTime time.Time
+		if session.ExpiryMinutes > 0 {
+			expiryTime = time.Now().Add(time.Duration(session.ExpiryMinutes) * time.Minute)
+		}
+
+		ctx := &uploadContext{
+			r:          r,
+			user:       user,
+			expiryTime: expiryTime,
+			tags:       session.Tags,
+			visibility: session.Visibility,
+			mergeTags:  session.MergeTags,
+			cfg:        cfg,
+		}
+
+		result := processImage(ctx, session.Filename, data)
+		if result.Status == "success" {
+			utils.DeleteUploadSession(r.Context(), session.ID, session.TotalChunks)
+		} else {
+			// Keep the chunks so the client can retry or abort the upload
+			utils.ReleaseUploadCompletion(r.Context(), session.ID)
+		}
+
+		logger.Info("Resumable upload completed",
+			zap.String("upload_id", session.ID),
+			zap.String("user_id", user.ID),
+			zap.String("status", result.Status),
+			zap.String("image_id", result.ID))
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{
+			"results": []UploadResult{result},
+		}); err != nil {
+			logger.Error("Failed to encode response", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,486 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Yuri-NagaSaki/ImageFlow/config"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils/errors"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils/logger"
+	"go.uber.org/zap"
+)
+
+// CollectionRequest represents the request body for creating or updating a collection.
+// Fields left out of an update are not changed.
+type CollectionRequest struct {
+	Name     *string   `json:"name"`     // Display name
+	ImageIDs *[]string `json:"imageIds"` // Initial images on create, the complete new order on update
+}
+
+// CollectionImagesRequest represents the request body for adding or removing images
+type CollectionImagesRequest struct {
+	IDs      []string `json:"ids"`      // Image IDs to add or remove
+	Position *int     `json:"position"` // Index to insert added images at, appended if omitted
+}
+
+// CollectionsResponse represents the response listing collections
+type CollectionsResponse struct {
+	Success     bool                `json:"success"`     // Whether the request was successful
+	Collections []*utils.Collection `json:"collections"` // Collections, oldest first
+}
+
+// CollectionResponse represents a collection together with a page of its images
+type CollectionResponse struct {
+	Success    bool              `json:"success"`          // Whether the request was successful
+	Collection *utils.Collection `json:"collection"`       // The collection
+	Images     []ImageInfo       `json:"images,omitempty"` // Images for current page, in collection order
+	Page       int               `json:"page,omitempty"`   // Current page number
+	Limit      int               `json:"limit,omitempty"`  // Number of items per page
+	TotalPages int               `json:"totalPages"`       // Total number of pages
+	Total      int               `json:"total"`            // Total number of images in the collection
+}
+
+// CollectionsHandler lists the caller's collections (GET) or creates a new one (POST)
+func CollectionsHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user from context (set by RequireAuth middleware)
+		user, ok := GetUserFromContext(r.Context())
+		if !ok {
+			errors.HandleError(w, errors.ErrUnauthorized, "Authentication required", nil)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			// API key users see every collection (backward compatibility)
+			ownerID := ""
+			if cfg.AuthType == config.AuthTypeOIDC && user.ID != "api_key_user" {
+				ownerID = user.ID
+			}
+
+			collections, err := utils.MetadataManager.ListCollections(r.Context(), ownerID)
+			if err != nil {
+				errors.HandleError(w, errors.ErrMetadata, "Failed to list collections", nil)
+				logger.Error("Failed to list collections",
+					zap.String("user_id", user.ID),
+					zap.Error(err))
+				return
+			}
+
+			writeCollectionJSON(w, http.StatusOK, CollectionsResponse{
+				Success:     true,
+				Collections: collections,
+			})
+
+		case http.MethodPost:
+			var req CollectionRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				errors.HandleError(w, errors.ErrInvalidParam, "Invalid request body", nil)
+				return
+			}
+			if req.Name == nil {
+				errors.HandleError(w, errors.ErrInvalidParam, "Collection name is required", nil)
+				return
+			}
+			name, err := normalizeCollectionName(*req.Name)
+			if err != nil {
+				errors.HandleError(w, errors.ErrInvalidParam, err.Error(), nil)
+				return
+			}
+
+			id, err := utils.NewCollectionID()
+			if err != nil {
+				errors.HandleError(w, errors.ErrInternal, "Failed to create collection", nil)
+				return
+			}
+
+			now := time.Now()
+			collection := &utils.Collection{
+				ID:        id,
+				UserID:    user.ID,
+				Name:      name,
+				ImageIDs:  []string{},
+				CreatedAt: now,
+				UpdatedAt: now,
+			}
+			if req.ImageIDs != nil {
+				if !addCollectionImages(w, r, cfg, user, collection, *req.ImageIDs, nil) {
+					return
+				}
+			}
+
+			if !saveCollection(w, r, collection) {
+				return
+			}
+
+			logger.Info("Collection created",
+				zap.String("collection_id", collection.ID),
+				zap.String("user_id", user.ID),
+				zap.Int("images", len(collection.ImageIDs)))
+
+			writeCollectionJSON(w, http.StatusCreated, CollectionResponse{
+				Success:    true,
+				Collection: collection,
+				Total:      len(collection.ImageIDs),
+			})
+
+		default:
+			errors.HandleError(w, errors.ErrInvalidParam, "Method not allowed", nil)
+		}
+	}
+}
+
+// CollectionHandler returns a page of a collection's images (GET), renames or reorders
+// it (PATCH) or deletes it (DELETE). Deleting a collection leaves its images in place.
+func CollectionHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user from context (set by RequireAuth middleware)
+		user, ok := GetUserFromContext(r.Context())
+		if !ok {
+			errors.HandleError(w, errors.ErrUnauthorized, "Authentication required", nil)
+			return
+		}
+
+		collection, ok := loadCollection(w, r, cfg, user, r.PathValue("id"))
+		if !ok {
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			writeCollectionPage(w, r, cfg, collection)
+
+		case http.MethodPatch:
+			var req CollectionRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				errors.HandleError(w, errors.ErrInvalidParam, "Invalid request body", nil)
+				return
+			}
+
+			if req.Name != nil {
+				name, err := normalizeCollectionName(*req.Name)
+				if err != nil {
+					errors.HandleError(w, errors.ErrInvalidParam, err.Error(), nil)
+					return
+				}
+				collection.Name = name
+			}
+			if req.ImageIDs != nil {
+				if !isPermutation(*req.ImageIDs, collection.ImageIDs) {
+					errors.HandleError(w, errors.ErrInvalidParam, "imageIds must list every image of the collection exactly once", nil)
+					return
+				}
+				collection.ImageIDs = *req.ImageIDs
+			}
+
+			collection.UpdatedAt = time.Now()
+			if !saveCollection(w, r, collection) {
+				return
+			}
+
+			logger.Info("Collection updated",
+				zap.String("collection_id", collection.ID),
+				zap.String("user_id", user.ID))
+
+			writeCollectionJSON(w, http.StatusOK, CollectionResponse{
+				Success:    true,
+				Collection: collection,
+				Total:      len(collection.ImageIDs),
+			})
+
+		case http.MethodDelete:
+			if err := utils.MetadataManager.DeleteCollection(r.Context(), collection.ID); err != nil {
+				errors.HandleError(w, errors.ErrMetadata, "Failed to delete collection", nil)
+				logger.Error("Failed to delete collection",
+					zap.String("collection_id", collection.ID),
+					zap.Error(err))
+				return
+			}
+
+			logger.Info("Collection deleted",
+				zap.String("collection_id", collection.ID),
+				zap.String("user_id", user.ID))
+
+			writeCollectionJSON(w, http.StatusOK, map[string]interface{}{
+				"success": true,
+				"message": "Collection deleted successfully",
+			})
+
+		default:
+			errors.HandleError(w, errors.ErrInvalidParam, "Method not allowed", nil)
+		}
+	}
+}
+
+// CollectionImagesHandler adds images to a collection (POST) or removes them (DELETE)
+func CollectionImagesHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			errors.HandleError(w, errors.ErrInvalidParam, "Method not allowed", nil)
+			return
+		}
+
+		// Get user from context (set by RequireAuth middleware)
+		user, ok := GetUserFromContext(r.Context())
+		if !ok {
+			errors.HandleError(w, errors.ErrUnauthorized, "Authentication required", nil)
+			return
+		}
+
+		collection, ok := loadCollection(w, r, cfg, user, r.PathValue("id"))
+		if !ok {
+			return
+		}
+
+		var req CollectionImagesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			errors.HandleError(w, errors.ErrInvalidParam, "Invalid request body", nil)
+			return
+		}
+		if len(req.IDs) == 0 {
+			errors.HandleError(w, errors.ErrInvalidParam, "No image IDs provided", nil)
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			if !addCollectionImages(w, r, cfg, user, collection, req.IDs, req.Position) {
+				return
+			}
+		} else {
+			removed := make(map[string]bool, len(req.IDs))
+			for _, id := range req.IDs {
+				removed[id] = true
+			}
+			remaining := make([]string, 0, len(collection.ImageIDs))
+			for _, id := range collection.ImageIDs {
+				if !removed[id] {
+					remaining = append(remaining, id)
+				}
+			}
+			collection.ImageIDs = remaining
+		}
+
+		collection.UpdatedAt = time.Now()
+		if !saveCollection(w, r, collection) {
+			return
+		}
+
+		logger.Info("Collection images changed",
+			zap.String("collection_id", collection.ID),
+			zap.String("method", r.Method),
+			zap.Int("ids", len(req.IDs)),
+			zap.Int("images", len(collection.ImageIDs)))
+
+		writeCollectionJSON(w, http.StatusOK, CollectionResponse{
+			Success:    true,
+			Collection: collection,
+			Total:      len(collection.ImageIDs),
+		})
+	}
+}
+
+// loadCollection fetches a collection and checks that the user may access it,
+// writing the error response otherwise
+func loadCollection(w http.ResponseWriter, r *http.Request, cfg *config.Config, user *utils.User, id string) (*utils.Collection, bool) {
+	if id == "" {
+		errors.HandleError(w, errors.ErrInvalidParam, "Collection ID is required", nil)
+		return nil, false
+	}
+	if !utils.IsValidCollectionID(id) {
+		errors.HandleError(w, errors.ErrInvalidParam, "Invalid collection ID", nil)
+		return nil, false
+	}
+
+	collection, err := utils.MetadataManager.GetCollection(r.Context(), id)
+	if err != nil {
+		errors.HandleError(w, errors.ErrNotFound, "Collection not found", nil)
+		return nil, false
+	}
+
+	// Verify collection ownership (except for API key users)
+	if cfg.AuthType == config.AuthTypeOIDC && user.ID != "api_key_user" && collection.UserID != user.ID {
+		errors.HandleError(w, errors.ErrForbidden, "You don't have permission to access this collection", nil)
+		logger.Warn("User attempted to access collection they don't own",
+			zap.String("user_id", user.ID),
+			zap.String("collection_id", id))
+		return nil, false
+	}
+	return collection, true
+}
+
+// addCollectionImages inserts images into a collection at a position, skipping those
+// already in it. Every image must exist and, for OIDC users, be owned by the caller.
+func addCollectionImages(w http.ResponseWriter, r *http.Request, cfg *config.Config, user *utils.User, collection *utils.Collection, ids []string, position *int) bool {
+	members := collection.MemberSet()
+	added := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id == "" || members[id] {
+			continue
+		}
+
+		if cfg.AuthType == config.AuthTypeOIDC && user.ID != "api_key_user" {
+			if err := utils.MetadataManager.VerifyImageOwnership(r.Context(), id, user.ID); err != nil {
+				errors.HandleError(w, errors.ErrForbidden, fmt.Sprintf("You don't have permission to use image %s", id), nil)
+				return false
+			}
+		} else if _, err := utils.MetadataManager.GetMetadata(r.Context(), id); err != nil {
+			errors.HandleError(w, errors.ErrNotFound, fmt.Sprintf("Image %s not found", id), nil)
+			return false
+		}
+
+		members[id] = true
+		added = append(added, id)
+	}
+
+	if len(collection.ImageIDs)+len(added) > utils.MaxCollectionImages {
+		errors.HandleError(w, errors.ErrInvalidParam, fmt.Sprintf("A collection can hold at most %d images", utils.MaxCollectionImages), nil)
+		return false
+	}
+
+	index := len(collection.ImageIDs)
+	if position != nil {
+		if *position < 0 || *position > len(collection.ImageIDs) {
+			errors.HandleError(w, errors.ErrInvalidParam, "position is out of range", nil)
+			return false
+		}
+		index = *position
+	}
+
+	imageIDs := make([]string, 0, len(collection.ImageIDs)+len(added))
+	imageIDs = append(imageIDs, collection.ImageIDs[:index]...)
+	imageIDs = append(imageIDs, added...)
+	imageIDs = append(imageIDs, collection.ImageIDs[index:]...)
+	collection.ImageIDs = imageIDs
+	return true
+}
+
+// saveCollection stores a collection, writing the error response on failure
+func saveCollection(w http.ResponseWriter, r *http.Request, collection *utils.Collection) bool {
+	if err := utils.MetadataManager.SaveCollection(r.Context(), collection); err != nil {
+		errors.HandleError(w, errors.ErrMetadata, "Failed to save collection", nil)
+		logger.Error("Failed to save collection",
+			zap.String("collection_id", collection.ID),
+			zap.Error(err))
+		return false
+	}
+	return true
+}
+
+// writeCollectionPage writes a collection with one page of its images in collection order.
+// Images deleted since they were added are left out of the page.
+func writeCollectionPage(w http.ResponseWriter, r *http.Request, cfg *config.Config, collection *utils.Collection) {
+	params := parseQueryParams(r)
+
+	total := len(collection.ImageIDs)
+	totalPages := int(math.Ceil(float64(total) / float64(params.limit)))
+	if params.page > totalPages && totalPages > 0 {
+		params.page = totalPages
+	}
+
+	startIdx := min((params.page-1)*params.limit, total)
+	endIdx := min(startIdx+params.limit, total)
+
+	images := make([]ImageInfo, 0, endIdx-startIdx)
+	for _, id := range collection.ImageIDs[startIdx:endIdx] {
+		metadata, err := utils.MetadataManager.GetMetadata(r.Context(), id)
+		if err != nil {
+			continue
+		}
+		images = append(images, collectionImageInfo(metadata, cfg, params.format))
+	}
+
+	writeCollectionJSON(w, http.StatusOK, CollectionResponse{
+		Success:    true,
+		Collection: collection,
+		Images:     images,
+		Page:       params.page,
+		Limit:      params.limit,
+		TotalPages: totalPages,
+		Total:      total,
+	})
+}
+
+// collectionImageInfo describes an image of a collection the way the image list does
+func collectionImageInfo(metadata *utils.ImageMetadata, cfg *config.Config, format string) ImageInfo {
+	imageInfo := ImageInfo{
+		ID:          metadata.ID,
+		FileName:    metadata.OriginalName,
+		Orientation: metadata.Orientation,
+		Width:       metadata.Width,
+		Height:      metadata.Height,
+		AspectRatio: metadata.Ratio(),
+		EXIF:        metadata.EXIF,
+		Format:      metadata.Format,
+		StorageType: string(cfg.StorageType),
+		Tags:        metadata.Tags,
+		Visibility:  metadata.Visibility,
+		URLs:        metadataURLs(metadata, cfg),
+		Size:        metadata.Sizes[format],
+	}
+	if imageInfo.Visibility == "" {
+		imageInfo.Visibility = utils.VisibilityPublic
+	}
+
+	imageInfo.URL = imageInfo.URLs[format]
+	if format != "original" {
+		baseName := strings.TrimSuffix(imageInfo.FileName, filepath.Ext(imageInfo.FileName))
+		imageInfo.FileName = baseName + "." + format
+	}
+	return imageInfo
+}
+
+// normalizeCollectionName trims a collection name and checks its length
+func normalizeCollectionName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("collection name cannot be empty")
+	}
+	if len([]rune(name)) > utils.MaxCollectionNameLength {
+		return "", fmt.Errorf("collection name cannot be longer than %d characters", utils.MaxCollectionNameLength)
+	}
+	return name, nil
+}
+
+// isPermutation reports whether order holds exactly the IDs of current, each once
+func isPermutation(order []string, current []string) bool {
+	if len(order) != len(current) {
+		return false
+	}
+	remaining := make(map[string]bool, len(current))
+	for _, id := range current {
+		remaining[id] = true
+	}
+	for _, id := range order {
+		if !remaining[id] {
+			return false
+		}
+		delete(remaining, id)
+	}
+	return true
+}
+
+// collectionFilter loads the collection named by the collection query parameter for
+// use as a filter and checks that the user may access it. It returns nil if the
+// parameter is not set, writing the error response when ok is false.
+func collectionFilter(w http.ResponseWriter, r *http.Request, cfg *config.Config, user *utils.User) (*utils.Collection, bool) {
+	id := r.URL.Query().Get("collection")
+	if id == "" {
+		return nil, true
+	}
+	return loadCollection(w, r, cfg, user, id)
+}
+
+// writeCollectionJSON writes a JSON response with a status code
+func writeCollectionJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
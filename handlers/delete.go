@@ -82,36 +82,15 @@ func DeleteImageHandler(cfg *config.Config) http.HandlerFunc {
 			}
 		}
 
-		var success bool
-		var message string
-
-		// Delete based on storage type
-		if cfg.StorageType == config.StorageTypeS3 {
-			success, message = deleteS3Images(req.ID, cfg)
-		} else {
-			success, message = deleteLocalImages(req.ID, cfg.ImageBasePath)
-		}
-
-		// If deletion was successful, clean up Redis data
-		if success && utils.IsRedisMetadataStore() {
-			// Create Redis metadata store
-			redisStore := utils.NewRedisMetadataStore()
-
-			// Delete metadata from Redis
-			if err := redisStore.DeleteMetadata(r.Context(), req.ID); err != nil {
-				logger.Warn("Failed to delete Redis metadata",
-					zap.String("image_id", req.ID),
-					zap.Error(err))
-			}
+		success, message := deleteImage(r.Context(), req.ID, cfg)
 
-			// Remove from images sorted set
-			if err := utils.RedisClient.ZRem(r.Context(), utils.RedisPrefix+"images", req.ID).Err(); err != nil {
-				logger.Warn("Failed to remove from images set",
+		// Clear page cache and drop the image from its collections
+		if success {
+			if err := utils.RemoveFromCollections(r.Context(), []string{req.ID}); err != nil {
+				logger.Warn("Failed to remove image from collections",
 					zap.String("image_id", req.ID),
 					zap.Error(err))
 			}
-
-			// Clear page cache
 			if err := utils.ClearPageCache(r.Context()); err != nil {
 				logger.Warn("Failed to clear page cache",
 					zap.String("image_id", req.ID),
@@ -141,11 +120,52 @@ func DeleteImageHandler(cfg *config.Config) http.HandlerFunc {
 	}
 }
 
+// deleteImage removes all stored files and the metadata of an image. Clearing the
+// page cache and updating collections is left to the caller so batch deletions only
+// do it once.
+func deleteImage(ctx context.Context, id string, cfg *config.Config) (bool, string) {
+	// Fetch metadata before deletion so derived files can be removed afterwards
+	metadata, err := utils.MetadataManager.GetMetadata(ctx, id)
+	if err != nil {
+		logger.Debug("No metadata found for image being deleted",
+			zap.String("image_id", id),
+			zap.Error(err))
+	}
+
+	var success bool
+	var message string
+
+	// Delete based on storage type
+	if cfg.StorageType == config.StorageTypeS3 {
+		success, message = deleteS3Images(id, cfg)
+	} else {
+		success, message = deleteLocalImages(id, cfg.ImageBasePath)
+	}
+
+	// Remove thumbnails and cached transform variants of the deleted image
+	if success {
+		utils.DeleteThumbnails(ctx, metadata)
+		utils.Variants.Purge(ctx, metadata)
+	}
+
+	// Delete the metadata in every store, which also drops the image from the
+	// listing, random and content hash indexes
+	if success {
+		if err := utils.MetadataManager.DeleteMetadata(ctx, id); err != nil {
+			logger.Warn("Failed to delete image metadata",
+				zap.String("image_id", id),
+				zap.Error(err))
+		}
+	}
+
+	return success, message
+}
+
 // deleteLocalImages deletes all formats of an image from local storage
 func deleteLocalImages(id string, basePath string) (bool, string) {
 	// Formats and orientations to check for image files
 	formats := []string{"original", "webp", "avif"}
-	orientations := []string{"landscape", "portrait"}
+	orientations := []string{"landscape", "portrait", "square"}
 
 	deletedCount := 0
 	errorCount := 0
@@ -250,7 +270,7 @@ func deleteS3Images(id string, cfg *config.Config) (bool, string) {
 
 	// Formats and orientations to check
 	formats := []string{"original", "webp", "avif"}
-	orientations := []string{"landscape", "portrait"}
+	orientations := []string{"landscape", "portrait", "square"}
 
 	// Build list of objects to delete
 	var objectsToDelete []types.ObjectIdentifier
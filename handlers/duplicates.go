@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math/bits"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Yuri-NagaSaki/ImageFlow/config"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils/errors"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultDuplicateThreshold = 8  // Hamming distance treated as near-identical by default
+	maxDuplicateThreshold     = 32 // Beyond half the hash bits, unrelated images start to match
+)
+
+// DuplicateImage describes one image of a near-duplicate cluster
+type DuplicateImage struct {
+	ID             string            `json:"id"`             // Image ID
+	OriginalName   string            `json:"originalName"`   // Original filename
+	UploadTime     string            `json:"uploadTime"`     // Upload timestamp (RFC3339)
+	Format         string            `json:"format"`         // Original format
+	Size           int64             `json:"size"`           // Size of the original file in bytes
+	PerceptualHash string            `json:"perceptualHash"` // Difference hash of the image
+	URLs           map[string]string `json:"urls"`           // URLs of the stored renditions
+}
+
+// DuplicateCluster is a group of images that are visually near-identical
+type DuplicateCluster struct {
+	MaxDistance int              `json:"maxDistance"` // Largest Hamming distance between two images of the cluster
+	Images      []DuplicateImage `json:"images"`      // Images of the cluster, oldest first
+}
+
+// DuplicatesResponse represents the response of the near-duplicate endpoint
+type DuplicatesResponse struct {
+	Success   bool               `json:"success"`   // Whether the request was successful
+	Threshold int                `json:"threshold"` // Hamming distance threshold used
+	Clusters  []DuplicateCluster `json:"clusters"`  // Clusters, largest first
+}
+
+// DuplicatesHandler lists clusters of visually near-identical images owned by the caller.
+// Two images belong to the same cluster when a chain of images connects them in which
+// each step differs by at most threshold bits of perceptual hash.
+func DuplicatesHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			errors.HandleError(w, errors.ErrInvalidParam, "Method not allowed", nil)
+			logger.Warn("Invalid request method",
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path))
+			return
+		}
+
+		// Get user from context (set by RequireAuth middleware)
+		user, ok := GetUserFromContext(r.Context())
+		if !ok {
+			errors.HandleError(w, errors.ErrUnauthorized, "Authentication required", nil)
+			return
+		}
+
+		threshold := defaultDuplicateThreshold
+		if value := r.URL.Query().Get("threshold"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 || parsed > maxDuplicateThreshold {
+				errors.HandleError(w, errors.ErrInvalidParam, "threshold must be between 0 and 32", nil)
+				return
+			}
+			threshold = parsed
+		}
+
+		// For OIDC users, only compare their own images
+		// For API key users, compare all images (backward compatibility)
+		var images []*utils.ImageMetadata
+		var err error
+		if cfg.AuthType == config.AuthTypeOIDC && user.ID != "api_key_user" {
+			images, err = utils.MetadataManager.GetUserMetadata(r.Context(), user.ID)
+		} else {
+			images, err = utils.MetadataManager.GetAllMetadata(r.Context())
+		}
+		if err != nil {
+			errors.HandleError(w, errors.ErrMetadata, "Failed to retrieve image metadata", nil)
+			logger.Error("Failed to load metadata for duplicate detection",
+				zap.String("user_id", user.ID),
+				zap.Error(err))
+			return
+		}
+
+		clusters := findDuplicateClusters(images, threshold, cfg)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(DuplicatesResponse{
+			Success:   true,
+			Threshold: threshold,
+			Clusters:  clusters,
+		}); err != nil {
+			logger.Error("Failed to encode response", zap.Error(err))
+		}
+
+		logger.Info("Near-duplicate detection completed",
+			zap.String("user_id", user.ID),
+			zap.Int("images", len(images)),
+			zap.Int("threshold", threshold),
+			zap.Int("clusters", len(clusters)))
+	}
+}
+
+// findDuplicateClusters groups images whose perceptual hashes are within threshold
+// of each other. Images without a perceptual hash (GIFs, older uploads) are skipped.
+func findDuplicateClusters(images []*utils.ImageMetadata, threshold int, cfg *config.Config) []DuplicateCluster {
+	// Hashes are parsed once, as every pair of images is compared
+	hashed := make([]*utils.ImageMetadata, 0, len(images))
+	hashes := make([]uint64, 0, len(images))
+	for _, metadata := range images {
+		if metadata.PerceptualHash == "" {
+			continue
+		}
+		hash, err := utils.ParsePerceptualHash(metadata.PerceptualHash)
+		if err != nil {
+			continue
+		}
+		hashed = append(hashed, metadata)
+		hashes = append(hashes, hash)
+	}
+
+	// Union-find over all pairs within the threshold
+	parent := make([]int, len(hashed))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for i := 0; i < len(hashed); i++ {
+		for j := i + 1; j < len(hashed); j++ {
+			if bits.OnesCount64(hashes[i]^hashes[j]) <= threshold {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	groups := make(map[int][]int)
+	for i := range hashed {
+		root := find(i)
+		groups[root] = append(groups[root], i)
+	}
+
+	clusters := make([]DuplicateCluster, 0)
+	for _, members := range groups {
+		if len(members) < 2 {
+			continue
+		}
+
+		sort.Slice(members, func(a, b int) bool {
+			return hashed[members[a]].UploadTime.Before(hashed[members[b]].UploadTime)
+		})
+
+		cluster := DuplicateCluster{Images: make([]DuplicateImage, 0, len(members))}
+		for i, member := range members {
+			for _, other := range members[i+1:] {
+				cluster.MaxDistance = max(cluster.MaxDistance, bits.OnesCount64(hashes[member]^hashes[other]))
+			}
+			metadata := hashed[member]
+			cluster.Images = append(cluster.Images, DuplicateImage{
+				ID:             metadata.ID,
+				OriginalName:   metadata.OriginalName,
+				UploadTime:     metadata.UploadTime.Format(time.RFC3339),
+				Format:         metadata.Format,
+				Size:           metadata.Sizes["original"],
+				PerceptualHash: metadata.PerceptualHash,
+				URLs:           metadataURLs(metadata, cfg),
+			})
+		}
+		clusters = append(clusters, cluster)
+	}
+
+	sort.Slice(clusters, func(a, b int) bool {
+		if len(clusters[a].Images) != len(clusters[b].Images) {
+			return len(clusters[a].Images) > len(clusters[b].Images)
+		}
+		return clusters[a].Images[0].UploadTime < clusters[b].Images[0].UploadTime
+	})
+
+	return clusters
+}
@@ -4,7 +4,6 @@ import (
 	"net/http"
 
 	"github.com/Yuri-NagaSaki/ImageFlow/config"
-	"github.com/Yuri-NagaSaki/ImageFlow/utils"
 	"github.com/Yuri-NagaSaki/ImageFlow/utils/errors"
 	"github.com/Yuri-NagaSaki/ImageFlow/utils/logger"
 	"go.uber.org/zap"
@@ -40,7 +39,7 @@ func RandomImage(cfg *config.Config) http.HandlerFunc {
 		if cfg.StorageType == config.StorageTypeS3 {
 			logger.Debug("Using S3 random image handler")
 			// Use the existing S3 handler
-			RandomImageHandler(utils.S3Client, cfg)(w, r)
+			RandomImageHandler(cfg)(w, r)
 		} else {
 			logger.Debug("Using local random image handler")
 			// Use the existing local handler
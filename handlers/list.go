@@ -74,6 +74,13 @@ func ListImagesHandler(cfg *config.Config) http.HandlerFunc {
 		// Parse query parameters
 		params := parseQueryParams(r)
 
+		// Restrict the listing to a collection the user may access
+		collection, ok := collectionFilter(w, r, cfg, user)
+		if !ok {
+			return
+		}
+		params.collection = collection
+
 		var allImages []ImageInfo
 
 		// Try to get from Redis if enabled
@@ -83,9 +90,13 @@ func ListImagesHandler(cfg *config.Config) http.HandlerFunc {
 		}
 
 		cacheKey := utils.CachedPageKey{
+			UserID:      user.ID,
 			Orientation: params.orientation,
 			Format:      params.format,
 			Tag:         params.tag,
+			Collection:  r.URL.Query().Get("collection"),
+			Ratio:       params.ratio.String(),
+			Dimensions:  params.dimensions.String(),
 			Page:        params.page,
 			Limit:       params.limit,
 		}
@@ -160,7 +171,10 @@ func ListImagesHandler(cfg *config.Config) http.HandlerFunc {
 type queryParams struct {
 	orientation string
 	format      string
-	tag         string // Tag to filter by
+	tag         string               // Tag to filter by
+	collection  *utils.Collection    // Collection to filter by, images are then listed in collection order
+	ratio       utils.RatioRange     // Aspect ratios to filter by (aspect, min_ratio, max_ratio)
+	dimensions  utils.DimensionRange // Pixel sizes to filter by (min_width, max_width, min_height, max_height)
 	page        int
 	limit       int
 }
@@ -198,7 +212,7 @@ func parseQueryParams(r *http.Request) queryParams {
 
 	// Default values
 	if orientation == "" {
-		orientation = "all" // all, landscape, portrait
+		orientation = "all" // all, landscape, portrait, square
 	}
 	if format == "" {
 		format = "original" // original, webp, avif
@@ -229,8 +243,11 @@ func parseQueryParams(r *http.Request) queryParams {
 		orientation: orientation,
 		format:      format,
 		tag:         tag,
-		page:        page,
-		limit:       limit,
+		ratio:       utils.ParseRatioRange(r.URL.Query().Get("aspect"), r.URL.Query().Get("min_ratio"), r.URL.Query().Get("max_ratio")),
+		dimensions: utils.ParseDimensionRange(r.URL.Query().Get("min_width"), r.URL.Query().Get("max_width"),
+			r.URL.Query().Get("min_height"), r.URL.Query().Get("max_height")),
+		page:  page,
+		limit: limit,
 	}
 }
 
@@ -289,6 +306,27 @@ func listImagesFromRedis(ctx context.Context, params queryParams, user *utils.Us
 		}
 	}
 
+	// Keep the images of the collection, in collection order
+	if params.collection != nil {
+		found := make(map[string]bool, len(imageIDs))
+		for _, id := range imageIDs {
+			found[id] = true
+		}
+
+		var collectionIDs []string
+		for _, id := range params.collection.ImageIDs {
+			if found[id] {
+				collectionIDs = append(collectionIDs, id)
+			}
+		}
+		imageIDs = collectionIDs
+	}
+
+	// Drop images outside the requested dimensions using the dimension indexes
+	if imageIDs, err = utils.FilterByDimensions(ctx, imageIDs, params.dimensions); err != nil {
+		return nil, err
+	}
+
 	if len(imageIDs) == 0 {
 		return []ImageInfo{}, nil
 	}
@@ -321,11 +359,25 @@ func listImagesFromRedis(ctx context.Context, params queryParams, user *utils.Us
 			continue
 		}
 
+		// Parse dimensions, missing for images uploaded before they were recorded
+		width, _ := strconv.Atoi(data["width"])
+		height, _ := strconv.Atoi(data["height"])
+		aspectRatio, _ := strconv.ParseFloat(data["aspectRatio"], 64)
+		if aspectRatio == 0 {
+			aspectRatio = utils.AspectRatio(width, height)
+		}
+
+		// Filter by aspect ratio if specified, images of unknown ratio never match
+		if !params.ratio.Contains(aspectRatio) {
+			continue
+		}
+
 		// Parse paths from JSON
 		var paths struct {
-			Original string `json:"original"`
-			WebP     string `json:"webp"`
-			AVIF     string `json:"avif"`
+			Original   string            `json:"original"`
+			WebP       string            `json:"webp"`
+			AVIF       string            `json:"avif"`
+			Thumbnails map[string]string `json:"thumbnails"`
 		}
 		if pathsStr := data["paths"]; pathsStr != "" {
 			if err := json.Unmarshal([]byte(pathsStr), &paths); err != nil {
@@ -340,16 +392,32 @@ func listImagesFromRedis(ctx context.Context, params queryParams, user *utils.Us
 			ID:          id,
 			FileName:    data["originalName"],
 			Orientation: data["orientation"],
+			Width:       width,
+			Height:      height,
+			AspectRatio: aspectRatio,
 			Format:      data["format"],
 			StorageType: string(cfg.StorageType),
+			Visibility:  data["visibility"],
 			URLs:        make(map[string]string, 3), // Pre-allocate with capacity
 		}
+		if imageInfo.Visibility == "" {
+			imageInfo.Visibility = utils.VisibilityPublic
+		}
 
 		// Parse tags
 		if tags := data["tags"]; tags != "" {
 			imageInfo.Tags = strings.Split(tags, ",")
 		}
 
+		// Parse camera data, missing for images uploaded before it was recorded
+		if exif := data["exif"]; exif != "" {
+			if err := json.Unmarshal([]byte(exif), &imageInfo.EXIF); err != nil {
+				logger.Warn("Failed to unmarshal EXIF data",
+					zap.String("image_id", id),
+					zap.Error(err))
+			}
+		}
+
 		// Get base URL for image access
 		baseURL := cfg.GetBaseURL()
 
@@ -384,6 +452,16 @@ func listImagesFromRedis(ctx context.Context, params queryParams, user *utils.Us
 				avifPath := filepath.Join(data["orientation"], "avif", id+".avif")
 				imageInfo.URLs["avif"] = fmt.Sprintf("%s/%s", baseURL, strings.ReplaceAll(avifPath, "\\", "/"))
 			}
+
+			// Thumbnails only exist for images uploaded after they were introduced
+			for variant, thumbPath := range paths.Thumbnails {
+				imageInfo.URLs[variant] = fmt.Sprintf("%s/%s", baseURL, strings.ReplaceAll(thumbPath, "\\", "/"))
+			}
+		}
+
+		// Private images are not publicly reachable, hand out short-lived signed URLs instead
+		if imageInfo.Visibility == utils.VisibilityPrivate {
+			imageInfo.URLs = signedImageURLs(cfg, id, imageInfo.URLs)
 		}
 
 		// Set the requested format URL
@@ -417,10 +495,12 @@ func listImagesFromRedis(ctx context.Context, params queryParams, user *utils.Us
 		images = append(images, imageInfo)
 	}
 
-	// Sort by filename in descending order
-	sort.Slice(images, func(i, j int) bool {
-		return images[i].FileName > images[j].FileName
-	})
+	// Sort by filename in descending order, collections keep their own order
+	if params.collection == nil {
+		sort.Slice(images, func(i, j int) bool {
+			return images[i].FileName > images[j].FileName
+		})
+	}
 
 	return images, nil
 }
@@ -338,7 +338,12 @@ func LogoutHandler(cfg *config.Config) http.HandlerFunc {
 	}
 }
 
-// UserProfileHandler returns current user profile
+// UpdateProfileRequest represents the request body for updating the current user's profile
+type UpdateProfileRequest struct {
+	Handle string `json:"handle"` // Public handle for per-user random image URLs
+}
+
+// UserProfileHandler returns current user profile, and updates its handle on PATCH
 func UserProfileHandler(cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user, err := utils.GetUserFromRequest(r)
@@ -347,6 +352,36 @@ func UserProfileHandler(cfg *config.Config) http.HandlerFunc {
 			return
 		}
 
+		if r.Method == http.MethodPatch {
+			var req UpdateProfileRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				errors.HandleError(w, errors.ErrInvalidParam, "Invalid request body", nil)
+				return
+			}
+
+			handle, err := utils.NormalizeHandle(req.Handle)
+			if err != nil {
+				errors.HandleError(w, errors.ErrInvalidParam, err.Error(), nil)
+				return
+			}
+
+			if err := utils.UserManager.SetHandle(r.Context(), user.ID, handle); err != nil {
+				if err == utils.ErrHandleTaken {
+					errors.HandleError(w, errors.ErrInvalidParam, err.Error(), nil)
+					return
+				}
+				errors.HandleError(w, errors.ErrInternal, "Failed to update profile", nil)
+				logger.Error("Failed to set user handle",
+					zap.String("user_id", user.ID),
+					zap.Error(err))
+				return
+			}
+			user.Handle = handle
+		} else if r.Method != http.MethodGet {
+			errors.HandleError(w, errors.ErrInvalidParam, "Method not allowed", nil)
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(user)
 	}
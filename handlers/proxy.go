@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/Yuri-NagaSaki/ImageFlow/config"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils/errors"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils/logger"
+	"go.uber.org/zap"
+)
+
+// ImageFileHandler serves any rendition of an image to its authenticated owner,
+// which is the only direct way to reach private images
+func ImageFileHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			errors.HandleError(w, errors.ErrInvalidParam, "Method not allowed", nil)
+			return
+		}
+
+		// Get user from context (set by RequireAuth middleware)
+		user, ok := GetUserFromContext(r.Context())
+		if !ok {
+			errors.HandleError(w, errors.ErrUnauthorized, "Authentication required", nil)
+			return
+		}
+
+		id := r.PathValue("id")
+
+		// Verify image ownership (except for API key users)
+		if cfg.AuthType == config.AuthTypeOIDC && user.ID != "api_key_user" {
+			if err := utils.MetadataManager.VerifyImageOwnership(r.Context(), id, user.ID); err != nil {
+				errors.HandleError(w, errors.ErrForbidden, "You don't have permission to access this image", nil)
+				logger.Warn("User attempted to access image they don't own",
+					zap.String("user_id", user.ID),
+					zap.String("image_id", id),
+					zap.Error(err))
+				return
+			}
+		}
+
+		metadata, err := utils.MetadataManager.GetMetadata(r.Context(), id)
+		if err != nil {
+			errors.HandleError(w, errors.ErrNotFound, "Image not found", nil)
+			return
+		}
+
+		key, ok := metadata.VariantPath(r.URL.Query().Get("variant"))
+		if !ok {
+			errors.HandleError(w, errors.ErrNotFound, "Image variant not found", nil)
+			return
+		}
+
+		serveStoredImage(w, r, key, "private, no-store")
+	}
+}
+
+// internalDirs are directories of the local image root holding server data rather
+// than images: chunks of unfinished resumable uploads, collection documents with
+// their image index and the content hash index
+var internalDirs = []string{utils.UploadStagingDir, "collections", utils.CollectionIndexDir, "hashes"}
+
+// GuardPrivateImages wraps the local image file server and refuses files that
+// belong to private images or internal directories. It expects the /images/ prefix to be stripped already.
+func GuardPrivateImages(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cleaned := path.Clean("/" + r.URL.Path)
+		for _, dir := range internalDirs {
+			if cleaned == "/"+dir || strings.HasPrefix(cleaned, "/"+dir+"/") {
+				errors.HandleError(w, errors.ErrNotFound, "Image not found", nil)
+				return
+			}
+		}
+		if id := utils.ImageIDFromKey(r.URL.Path); id != "" && utils.MetadataManager != nil {
+			if metadata, err := utils.MetadataManager.GetMetadata(r.Context(), id); err == nil && metadata.IsPrivate() {
+				errors.HandleError(w, errors.ErrNotFound, "Image not found", nil)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
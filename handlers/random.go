@@ -2,12 +2,17 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"io"
+	"hash/fnv"
+	"image"
+	"math"
 	"math/rand"
 	"net/http"
-	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,17 +20,49 @@ import (
 	"github.com/Yuri-NagaSaki/ImageFlow/utils"
 	"github.com/Yuri-NagaSaki/ImageFlow/utils/errors"
 	"github.com/Yuri-NagaSaki/ImageFlow/utils/logger"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"go.uber.org/zap"
 )
 
 // RandomQueryParams holds all query parameters for random image API
 type RandomQueryParams struct {
-	Tags        []string // Multiple tags (comma-separated)
-	ExcludeTags []string // Tags to exclude (comma-separated)
-	Orientation string   // portrait, landscape, or both
-	Format      string   // preferred format hint
+	Tags        []string             // Multiple tags (comma-separated)
+	ExcludeTags []string             // Tags to exclude (comma-separated)
+	Orientation string               // portrait, landscape or square, empty to decide from the device
+	Format      string               // preferred format hint
+	Mode        string               // proxy or redirect, empty for the configured default
+	JSON        bool                 // describe the selected image as JSON instead of sending it
+	Count       int                  // number of distinct images to select (JSON list when above 1)
+	Seed        int64                // seed for reproducible selections
+	Seeded      bool                 // whether a seed was given
+	Bias        []string             // selection biases: recent and/or rare
+	Collection  string               // only select images of this collection, if set
+	Ratio       utils.RatioRange     // aspect ratios to select from (aspect, min_ratio, max_ratio)
+	Dimensions  utils.DimensionRange // pixel sizes to select from (min_width, max_width, min_height, max_height)
+}
+
+// maxRandomCount caps the number of images a single random request may select
+const maxRandomCount = 50
+
+// Random selection biases
+const (
+	BiasRecent = "recent" // favor recent uploads
+	BiasRare   = "rare"   // favor images the random API has served less often
+)
+
+// recentBiasHalfLife is the image age at which the recent bias halves the selection weight
+const recentBiasHalfLife = 30 * 24 * time.Hour
+
+// RandomImageResponse describes a randomly selected image in JSON mode
+type RandomImageResponse struct {
+	ID          string            `json:"id"`                    // Image ID
+	URL         string            `json:"url"`                   // URL of the rendition best suited to the client
+	URLs        map[string]string `json:"urls"`                  // URLs of all stored renditions keyed by variant
+	Width       int               `json:"width,omitempty"`       // Width of the original in pixels
+	Height      int               `json:"height,omitempty"`      // Height of the original in pixels
+	AspectRatio float64           `json:"aspectRatio,omitempty"` // Width divided by height
+	Tags        []string          `json:"tags"`                  // Image tags
+	Orientation string            `json:"orientation"`           // Image orientation
+	Format      string            `json:"format"`                // Original format
 }
 
 // parseRandomQueryParams extracts and validates query parameters
@@ -59,13 +96,64 @@ func parseRandomQueryParams(r *http.Request) *RandomQueryParams {
 
 	// Parse orientation
 	params.Orientation = strings.ToLower(r.URL.Query().Get("orientation"))
-	if params.Orientation != "portrait" && params.Orientation != "landscape" {
+	if params.Orientation != "portrait" && params.Orientation != "landscape" && params.Orientation != "square" {
 		params.Orientation = "" // Will be auto-detected
 	}
 
+	// Parse aspect ratio filters
+	params.Ratio = utils.ParseRatioRange(r.URL.Query().Get("aspect"),
+		r.URL.Query().Get("min_ratio"), r.URL.Query().Get("max_ratio"))
+
+	// Parse dimension filters
+	params.Dimensions = utils.ParseDimensionRange(r.URL.Query().Get("min_width"), r.URL.Query().Get("max_width"),
+		r.URL.Query().Get("min_height"), r.URL.Query().Get("max_height"))
+
 	// Parse format preference
 	params.Format = strings.ToLower(r.URL.Query().Get("format"))
 
+	// JSON mode is requested explicitly or through content negotiation
+	params.JSON = strings.EqualFold(r.URL.Query().Get("type"), "json") ||
+		strings.Contains(r.Header.Get("Accept"), "application/json")
+
+	// Parse batch size
+	params.Count = 1
+	if countStr := r.URL.Query().Get("count"); countStr != "" {
+		if count, err := strconv.Atoi(countStr); err == nil && count > 1 {
+			params.Count = min(count, maxRandomCount)
+		}
+	}
+
+	// Parse seed; non-numeric seeds are hashed so any string gives a stable selection
+	if seedStr := r.URL.Query().Get("seed"); seedStr != "" {
+		params.Seeded = true
+		if seed, err := strconv.ParseInt(seedStr, 10, 64); err == nil {
+			params.Seed = seed
+		} else {
+			h := fnv.New64a()
+			h.Write([]byte(seedStr))
+			params.Seed = int64(h.Sum64())
+		}
+	}
+
+	// Parse selection biases
+	if biasStr := r.URL.Query().Get("bias"); biasStr != "" {
+		for _, bias := range strings.Split(biasStr, ",") {
+			bias = strings.ToLower(strings.TrimSpace(bias))
+			if bias == BiasRecent || bias == BiasRare {
+				params.Bias = append(params.Bias, bias)
+			}
+		}
+	}
+
+	// Parse collection filter
+	params.Collection = r.URL.Query().Get("collection")
+
+	// Parse delivery mode
+	params.Mode = strings.ToLower(r.URL.Query().Get("mode"))
+	if params.Mode != config.RandomModeProxy && params.Mode != config.RandomModeRedirect {
+		params.Mode = "" // Will use the configured default
+	}
+
 	return params
 }
 
@@ -99,6 +187,103 @@ func matchesTags(imageTags []string, requiredTags []string, excludeTags []string
 	return true
 }
 
+// newRandomSource returns the random source for a request, seeded from the
+// request's seed when one was given so the same selection can be reproduced
+func newRandomSource(params *RandomQueryParams) *rand.Rand {
+	if params.Seeded {
+		return rand.New(rand.NewSource(params.Seed))
+	}
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
+}
+
+// selectionWeights returns the relative selection weight of each candidate from the
+// per-image weights and the requested biases, or nil when every candidate weighs the
+// same. Weighting needs the Redis indexes; without Redis selection stays uniform.
+func selectionWeights(ctx context.Context, params *RandomQueryParams, ids []string) []float64 {
+	if !utils.IsRedisMetadataStore() || len(ids) == 0 {
+		return nil
+	}
+
+	stats, err := utils.GetRandomStats(ctx, ids)
+	if err != nil {
+		logger.Warn("Failed to read random selection weights, selecting uniformly", zap.Error(err))
+		return nil
+	}
+
+	now := time.Now()
+	weights := make([]float64, len(ids))
+	uniform := true
+	for i, stat := range stats {
+		weight := stat.Weight
+		for _, bias := range params.Bias {
+			switch bias {
+			case BiasRecent:
+				if !stat.UploadTime.IsZero() {
+					age := max(now.Sub(stat.UploadTime), 0)
+					weight *= math.Exp2(-float64(age) / float64(recentBiasHalfLife))
+				}
+			case BiasRare:
+				weight /= float64(1 + stat.Served)
+			}
+		}
+		weights[i] = weight
+		if weight != weights[0] {
+			uniform = false
+		}
+	}
+
+	if uniform {
+		return nil
+	}
+	return weights
+}
+
+// weightedPerm returns a random permutation of the candidate indexes in which each
+// candidate appears earlier in proportion to its weight (Efraimidis-Spirakis sampling)
+func weightedPerm(rng *rand.Rand, weights []float64) []int {
+	keys := make([]float64, len(weights))
+	perm := make([]int, len(weights))
+	for i, weight := range weights {
+		perm[i] = i
+		if weight <= 0 {
+			keys[i] = math.Inf(-1)
+			continue
+		}
+		// 1-Float64 lies in (0, 1], so the logarithm is finite
+		keys[i] = math.Log(1-rng.Float64()) / weight
+	}
+	sort.SliceStable(perm, func(a, b int) bool {
+		return keys[perm[a]] > keys[perm[b]]
+	})
+	return perm
+}
+
+// randomOrder returns a random order of n candidates, weighted when weights are given
+func randomOrder(rng *rand.Rand, n int, weights []float64) []int {
+	if weights != nil {
+		return weightedPerm(rng, weights)
+	}
+	return rng.Perm(n)
+}
+
+// pickListedIndexes returns the indexes of up to count distinct random candidates that
+// may appear in random results, drawn in proportion to weights when given. Candidates
+// found by scanning storage are checked lazily so only drawn images need a metadata
+// lookup; files without metadata are public.
+func pickListedIndexes(rng *rand.Rand, ids []string, count int, weights []float64) []int {
+	picked := make([]int, 0, count)
+	for _, i := range randomOrder(rng, len(ids), weights) {
+		if len(picked) == count {
+			break
+		}
+		metadata, err := utils.MetadataManager.GetMetadata(context.Background(), ids[i])
+		if err != nil || metadata.IsListed() {
+			picked = append(picked, i)
+		}
+	}
+	return picked
+}
+
 // Image format constants
 const (
 	FormatAVIF     = "avif"
@@ -155,6 +340,11 @@ func getContentType(format string, filename string) string {
 // setImageResponseHeaders sets standard HTTP headers for image responses
 func setImageResponseHeaders(w http.ResponseWriter, contentType string) {
 	w.Header().Set("Content-Type", contentType)
+	setNoCacheHeaders(w)
+}
+
+// setNoCacheHeaders keeps clients and proxies from caching a random selection
+func setNoCacheHeaders(w http.ResponseWriter) {
 	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
 	w.Header().Set("Pragma", "no-cache")
 	w.Header().Set("Expires", "0")
@@ -180,7 +370,7 @@ func getFormattedImagePath(format string, orientation string, filename string) s
 }
 
 // RandomImageHandler serves random images from S3 storage
-func RandomImageHandler(s3Client *s3.Client, cfg *config.Config) http.HandlerFunc {
+func RandomImageHandler(cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if !cfg.S3Enabled {
 			errors.HandleError(w, errors.ErrInternal, "S3 storage is not enabled", nil)
@@ -189,14 +379,24 @@ func RandomImageHandler(s3Client *s3.Client, cfg *config.Config) http.HandlerFun
 
 		// Parse query parameters
 		params := parseRandomQueryParams(r)
+		if !checkRandomBias(w, params) {
+			return
+		}
+		collection, ok := loadRandomCollection(w, r, params)
+		if !ok {
+			return
+		}
 
 		// Determine device type and orientation
 		deviceType := utils.DetectDeviceType(r)
 		orientation := determineOrientation(r, deviceType)
 
-		// Override orientation if specified in params
+		// Override orientation if specified in params; aspect ratio filters otherwise
+		// accept images of any orientation
 		if params.Orientation != "" {
 			orientation = params.Orientation
+		} else if params.Ratio.IsSet() {
+			orientation = ""
 		}
 
 		logger.Info("Processing random image request",
@@ -205,99 +405,74 @@ func RandomImageHandler(s3Client *s3.Client, cfg *config.Config) http.HandlerFun
 			zap.String("orientation", orientation),
 			zap.String("device_type", deviceType))
 
-		// Find matching images
-		var matchingImages []string
-		var err error
-
-		// Use Redis for efficient filtering if available and tags are specified
-		if (len(params.Tags) > 0 || len(params.ExcludeTags) > 0) && utils.IsRedisMetadataStore() {
-			var candidateIDs []string
-
-			if len(params.Tags) > 0 {
-				// Get images that have ALL required tags
-				candidateIDs, err = utils.GetImagesByMultipleTags(context.Background(), params.Tags)
-				if err != nil {
-					logger.Error("Failed to get images by tags from Redis", zap.Error(err))
-					// Fall back to traditional method
-				}
-			} else {
-				// Get all image IDs if only exclude filters are specified
-				candidateIDs, err = utils.GetAllImageIDs(context.Background())
-				if err != nil {
-					logger.Error("Failed to get all image IDs from Redis", zap.Error(err))
-				}
+		// Draw from the Redis random indexes when available
+		if utils.IsRedisMetadataStore() {
+			selected := selectIndexedRandomImages(r.Context(), params, utils.RandomFilter{
+				Orientation: orientation,
+				Tags:        params.Tags,
+				ExcludeTags: params.ExcludeTags,
+				ImageIDs:    collection.imageIDs(),
+				Ratio:       params.Ratio,
+				Dimensions:  params.Dimensions,
+			})
+			// The indexes hold every listed image, so an empty result needs no storage scan
+			if len(selected) == 0 {
+				errors.HandleError(w, errors.ErrNotFound, "No images found matching criteria", nil)
+				return
 			}
 
-			if err == nil && len(candidateIDs) > 0 {
-				// Filter by metadata
-				for _, id := range candidateIDs {
-					metadata, metaErr := utils.MetadataManager.GetMetadata(context.Background(), id)
-					if metaErr != nil {
-						continue
-					}
-
-					// Check tag matching
-					if !matchesTags(metadata.Tags, params.Tags, params.ExcludeTags) {
-						continue
-					}
-
-					// Check orientation
-					if metadata.Orientation == orientation {
-						matchingImages = append(matchingImages, metadata.Paths.Original)
-					}
-				}
-
-				logger.Info("Found matching images from Redis",
-					zap.Int("count", len(matchingImages)))
+			keys := make([]string, len(selected))
+			contentTypes := make([]string, len(selected))
+			for i, metadata := range selected {
+				logger.Debug("Selected random image", zap.String("key", metadata.Paths.Original))
+				keys[i], contentTypes[i] = resolveMetadataRendition(r, params, metadata)
 			}
+			deliverRandomImages(w, r, cfg, params, keys, contentTypes)
+			return
 		}
 
-		// Fall back to S3 listing if Redis didn't work or no results
-		if len(matchingImages) == 0 {
-			// Build prefix for orientation directory
-			prefix := fmt.Sprintf("original/%s/", orientation)
+		// Without Redis, find matching images by listing S3
+		var matchingImages []string
+		objects, err := listRandomOriginals(r.Context(), orientation)
+		if err != nil {
+			logger.Error("Failed to list objects from S3", zap.Error(err))
+			errors.HandleError(w, errors.ErrInternal, "Failed to list images", err)
+			return
+		}
 
-			output, err := s3Client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
-				Bucket: aws.String(cfg.S3Bucket),
-				Prefix: aws.String(prefix),
-			})
+		// Filter images based on criteria
+		for _, obj := range objects {
+			if !utils.IsImageFile(obj.Key) {
+				continue
+			}
 
-			if err != nil {
-				logger.Error("Failed to list objects from S3", zap.Error(err))
-				errors.HandleError(w, errors.ErrInternal, "Failed to list images", err)
-				return
+			// Extract ID for metadata lookup
+			fileBaseName := path.Base(obj.Key)
+			id := strings.TrimSuffix(fileBaseName, filepath.Ext(fileBaseName))
+			if !collection.contains(id) {
+				continue
 			}
 
-			// Filter images based on criteria
-			for _, obj := range output.Contents {
-				if !utils.IsImageFile(*obj.Key) {
+			// Get metadata for tag, aspect ratio and dimension filtering
+			if len(params.Tags) > 0 || len(params.ExcludeTags) > 0 || params.Ratio.IsSet() || params.Dimensions.IsSet() {
+				metadata, metaErr := utils.MetadataManager.GetMetadata(context.Background(), id)
+				if metaErr != nil {
+					// Skip if metadata not found
 					continue
 				}
 
-				// Extract ID for metadata lookup
-				fileBaseName := filepath.Base(*obj.Key)
-				id := strings.TrimSuffix(fileBaseName, filepath.Ext(fileBaseName))
-
-				// Get metadata for tag filtering
-				if len(params.Tags) > 0 || len(params.ExcludeTags) > 0 {
-					metadata, metaErr := utils.MetadataManager.GetMetadata(context.Background(), id)
-					if metaErr != nil {
-						// Skip if metadata not found
-						continue
-					}
-
-					if !matchesTags(metadata.Tags, params.Tags, params.ExcludeTags) {
-						continue
-					}
+				if !matchesTags(metadata.Tags, params.Tags, params.ExcludeTags) || !params.Ratio.Contains(metadata.Ratio()) ||
+					!params.Dimensions.Contains(metadata.Width, metadata.Height) {
+					continue
 				}
-
-				matchingImages = append(matchingImages, *obj.Key)
 			}
 
-			logger.Info("Found matching images from S3 listing",
-				zap.Int("count", len(matchingImages)))
+			matchingImages = append(matchingImages, obj.Key)
 		}
 
+		logger.Info("Found matching images from S3 listing",
+			zap.Int("count", len(matchingImages)))
+
 		if len(matchingImages) == 0 {
 			logger.Warn("No images found matching criteria",
 				zap.Strings("tags", params.Tags),
@@ -307,85 +482,452 @@ func RandomImageHandler(s3Client *s3.Client, cfg *config.Config) http.HandlerFun
 			return
 		}
 
-		// Select random image
-		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
-		randomIndex := rng.Intn(len(matchingImages))
-		originalKey := matchingImages[randomIndex]
-		logger.Debug("Selected random image", zap.String("key", originalKey))
+		// Storage listings come in no guaranteed order, so seeded selections need a stable order
+		if params.Seeded {
+			sort.Strings(matchingImages)
+		}
 
-		// Extract filename for format path generation
-		fileBaseName := filepath.Base(originalKey)
-		filename := strings.TrimSuffix(fileBaseName, filepath.Ext(fileBaseName))
+		// Select random images
+		candidateIDs := make([]string, len(matchingImages))
+		for i, key := range matchingImages {
+			candidateIDs[i] = utils.ImageIDFromKey(key)
+		}
+		weights := selectionWeights(r.Context(), params, candidateIDs)
+		picked := pickListedIndexes(newRandomSource(params), candidateIDs, params.Count, weights)
+		if len(picked) == 0 {
+			errors.HandleError(w, errors.ErrNotFound, "No images found matching criteria", nil)
+			return
+		}
+
+		keys := make([]string, len(picked))
+		contentTypes := make([]string, len(picked))
+		for i, index := range picked {
+			logger.Debug("Selected random image", zap.String("key", matchingImages[index]))
+			// The orientation directory of the original, which may differ per image without an orientation filter
+			imageOrientation := path.Base(path.Dir(matchingImages[index]))
+			keys[i], contentTypes[i] = resolveRandomRendition(r, params, imageOrientation, matchingImages[index])
+		}
+
+		deliverRandomImages(w, r, cfg, params, keys, contentTypes)
+	}
+}
+
+// listRandomOriginals lists the stored originals of an orientation, or of every
+// orientation when it is empty
+func listRandomOriginals(ctx context.Context, orientation string) ([]utils.ObjectInfo, error) {
+	orientations := []string{orientation}
+	if orientation == "" {
+		orientations = utils.RandomOrientations
+	}
+
+	var objects []utils.ObjectInfo
+	for _, o := range orientations {
+		listed, err := utils.Storage.List(ctx, fmt.Sprintf("original/%s/", o))
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, listed...)
+	}
+	return objects, nil
+}
 
-		// Determine best format
-		bestFormat := detectBestFormat(r)
-		if params.Format != "" {
-			// Override with user preference if valid
-			switch params.Format {
-			case "avif", "webp", "original":
-				bestFormat = params.Format
+// randomCollection restricts random selection to the images of a collection.
+// A nil value places no restriction.
+type randomCollection struct {
+	ids     []string        // Image IDs of the collection
+	members map[string]bool // The same IDs as a set
+}
+
+// imageIDs returns the images selection is restricted to, nil for no restriction
+func (c *randomCollection) imageIDs() []string {
+	if c == nil {
+		return nil
+	}
+	return c.ids
+}
+
+// contains reports whether an image may be selected
+func (c *randomCollection) contains(id string) bool {
+	return c == nil || c.members[id]
+}
+
+// checkRandomBias rejects selection biases without the Redis metadata store, whose
+// indexes hold the upload times and serve counts they weigh by
+func checkRandomBias(w http.ResponseWriter, params *RandomQueryParams) bool {
+	if len(params.Bias) > 0 && !utils.IsRedisMetadataStore() {
+		errors.HandleError(w, errors.ErrInvalidParam, "bias requires the Redis metadata store", nil)
+		return false
+	}
+	return true
+}
+
+// loadRandomCollection loads the collection named by the collection parameter. The
+// random API is public, so the unguessable collection ID is all that is required;
+// only listed images of the collection are ever selected.
+func loadRandomCollection(w http.ResponseWriter, r *http.Request, params *RandomQueryParams) (*randomCollection, bool) {
+	if params.Collection == "" {
+		return nil, true
+	}
+	if !utils.IsValidCollectionID(params.Collection) {
+		errors.HandleError(w, errors.ErrInvalidParam, "Invalid collection ID", nil)
+		return nil, false
+	}
+
+	collection, err := utils.MetadataManager.GetCollection(r.Context(), params.Collection)
+	if err != nil {
+		errors.HandleError(w, errors.ErrNotFound, "Collection not found", nil)
+		return nil, false
+	}
+	if len(collection.ImageIDs) == 0 {
+		errors.HandleError(w, errors.ErrNotFound, "No images found matching criteria", nil)
+		return nil, false
+	}
+
+	return &randomCollection{
+		ids:     collection.ImageIDs,
+		members: collection.MemberSet(),
+	}, true
+}
+
+// selectIndexedRandomImages draws up to params.Count images matching a filter from the
+// Redis random indexes, which only hold listed images. Plain requests are sampled by
+// Redis directly, weights included; tag filters, seeds and biases work on the
+// filtered candidate IDs.
+// Only the metadata of the drawn images is read.
+func selectIndexedRandomImages(ctx context.Context, params *RandomQueryParams, filter utils.RandomFilter) []*utils.ImageMetadata {
+	var ids []string
+	plain := filter.Orientation != "" && len(filter.Tags) == 0 && len(filter.ExcludeTags) == 0 && filter.UserID == "" &&
+		filter.ImageIDs == nil && !filter.Ratio.IsSet() && !filter.Dimensions.IsSet() && !params.Seeded && len(params.Bias) == 0
+	if plain {
+		sample, err := utils.SampleRandomIDs(ctx, filter, params.Count)
+		if err != nil {
+			// Too many custom weights to sample, select from the full candidate list
+			logger.Debug("Failed to sample random index", zap.Error(err))
+			plain = false
+		} else {
+			ids = drawRandomSample(newRandomSource(params), sample, params.Count)
+		}
+	}
+	if !plain {
+		candidates, err := utils.RandomCandidateIDs(ctx, filter)
+		if err != nil {
+			logger.Error("Failed to read random indexes", zap.Error(err))
+			return nil
+		}
+
+		// Set members come back in arbitrary order, so seeded selections need a stable order
+		if params.Seeded {
+			sort.Strings(candidates)
+		}
+
+		weights := selectionWeights(ctx, params, candidates)
+		for _, i := range randomOrder(newRandomSource(params), len(candidates), weights) {
+			if len(ids) == params.Count {
+				break
 			}
+			ids = append(ids, candidates[i])
 		}
+	}
 
-		// Handle PNG transparency preservation
-		isPNG := strings.HasSuffix(strings.ToLower(originalKey), ".png")
-		if isPNG && bestFormat == FormatOriginal {
-			serveS3Image(s3Client, cfg, w, r, originalKey, "image/png")
+	selected := make([]*utils.ImageMetadata, 0, len(ids))
+	for _, id := range ids {
+		metadata, err := utils.MetadataManager.GetMetadata(ctx, id)
+		if err != nil {
+			logger.Warn("Random index refers to missing metadata",
+				zap.String("id", id),
+				zap.Error(err))
+			continue
+		}
+		selected = append(selected, metadata)
+	}
+
+	logger.Debug("Selected images from random indexes",
+		zap.Bool("sampled", plain),
+		zap.Int("count", len(selected)))
+	return selected
+}
+
+// drawRandomSample selects up to count images of a weighted sample one at a time, each
+// time choosing between the default-weight images, which weigh 1 each and are drawn
+// in sampled order, and the custom-weight images in proportion to the remaining weight
+func drawRandomSample(rng *rand.Rand, sample *utils.RandomSample, count int) []string {
+	weightedIDs := make([]string, 0, len(sample.Weighted))
+	for id := range sample.Weighted {
+		weightedIDs = append(weightedIDs, id)
+	}
+	sort.Strings(weightedIDs)
+
+	defaults := sample.Defaults
+	defaultWeight := float64(sample.DefaultCount)
+	ids := make([]string, 0, count)
+	for len(ids) < count {
+		if len(defaults) == 0 {
+			defaultWeight = 0
+		}
+		total := defaultWeight
+		for _, id := range weightedIDs {
+			total += sample.Weighted[id]
+		}
+		if total <= 0 {
+			break
+		}
+
+		x := rng.Float64() * total
+		if x < defaultWeight {
+			ids = append(ids, defaults[0])
+			defaults = defaults[1:]
+			defaultWeight--
+			continue
+		}
+		x -= defaultWeight
+		for i, id := range weightedIDs {
+			if x < sample.Weighted[id] || i == len(weightedIDs)-1 {
+				ids = append(ids, id)
+				weightedIDs = append(weightedIDs[:i], weightedIDs[i+1:]...)
+				break
+			}
+			x -= sample.Weighted[id]
+		}
+	}
+	return ids
+}
+
+// resolveRandomRendition picks the stored rendition of a selected image best suited
+// to the client, returning its key and content type
+func resolveRandomRendition(r *http.Request, params *RandomQueryParams, orientation string, originalKey string) (string, string) {
+	// Extract filename for format path generation
+	fileBaseName := filepath.Base(originalKey)
+	filename := strings.TrimSuffix(fileBaseName, filepath.Ext(fileBaseName))
+
+	// Determine best format
+	bestFormat := detectBestFormat(r)
+	if params.Format != "" {
+		// Override with user preference if valid
+		switch params.Format {
+		case "avif", "webp", "original":
+			bestFormat = params.Format
+		}
+	}
+
+	// Handle PNG transparency preservation
+	isPNG := strings.HasSuffix(strings.ToLower(originalKey), ".png")
+	if isPNG && bestFormat == FormatOriginal {
+		return originalKey, "image/png"
+	}
+
+	// Try preferred format first
+	imageKey := getFormattedImagePath(bestFormat, orientation, filename)
+	contentType := getContentType(bestFormat, imageKey)
+
+	// Fall back to original if preferred format not available
+	if _, err := utils.Storage.Stat(r.Context(), imageKey); err != nil {
+		logger.Info("Preferred format not available, falling back to original",
+			zap.String("preferred", bestFormat))
+		imageKey = originalKey
+		contentType = getContentType(FormatOriginal, originalKey)
+	}
+
+	return imageKey, contentType
+}
+
+// deliverRandomImages sends the selected images. Several images can only be
+// described as JSON; a single image is delivered according to the request mode.
+func deliverRandomImages(w http.ResponseWriter, r *http.Request, cfg *config.Config, params *RandomQueryParams, keys []string, contentTypes []string) {
+	// Serve counts feed the rare bias
+	ids := make([]string, len(keys))
+	for i, key := range keys {
+		ids[i] = utils.ImageIDFromKey(key)
+	}
+	utils.RecordRandomServes(r.Context(), ids)
+
+	if params.Count == 1 {
+		deliverRandomImage(w, r, cfg, params, keys[0], contentTypes[0])
+		return
+	}
+
+	images := make([]RandomImageResponse, len(keys))
+	for i, key := range keys {
+		images[i] = describeRandomImage(r, cfg, key)
+	}
+
+	setNoCacheHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"images": images,
+	}); err != nil {
+		logger.Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+// UserRandomImageHandler serves random images from the public images of the user
+// owning the handle in the URL, for per-user random wallpaper feeds
+func UserRandomImageHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			errors.HandleError(w, errors.ErrInvalidParam, "Method not allowed", nil)
 			return
 		}
 
-		// Try preferred format first
-		imageKey := getFormattedImagePath(bestFormat, orientation, filename)
-		contentType := getContentType(bestFormat, imageKey)
+		handle := strings.ToLower(r.PathValue("handle"))
+		owner, err := utils.UserManager.GetUserByHandle(r.Context(), handle)
+		if err != nil || !owner.IsActive {
+			errors.HandleError(w, errors.ErrNotFound, "User not found", nil)
+			logger.Debug("Random image requested for unknown handle",
+				zap.String("handle", handle),
+				zap.Error(err))
+			return
+		}
 
-		// Try to serve the preferred format
-		data, err := s3Client.GetObject(r.Context(), &s3.GetObjectInput{
-			Bucket: aws.String(cfg.S3Bucket),
-			Key:    aws.String(imageKey),
-		})
+		params := parseRandomQueryParams(r)
+		if !checkRandomBias(w, params) {
+			return
+		}
+		collection, ok := loadRandomCollection(w, r, params)
+		if !ok {
+			return
+		}
 
-		if err != nil {
-			// Fall back to original if preferred format not available
-			logger.Info("Preferred format not available, falling back to original",
-				zap.String("preferred", bestFormat))
-			serveS3Image(s3Client, cfg, w, r, originalKey, getContentType(FormatOriginal, originalKey))
+		// Determine orientation from the device unless specified in params
+		orientation := "landscape"
+		if utils.DetectDeviceType(r) == utils.DeviceMobile {
+			orientation = "portrait"
+		}
+		if params.Orientation != "" {
+			orientation = params.Orientation
+		} else if params.Ratio.IsSet() {
+			orientation = ""
+		}
+
+		logger.Info("Processing user random image request",
+			zap.String("handle", handle),
+			zap.Strings("tags", params.Tags),
+			zap.Strings("exclude_tags", params.ExcludeTags),
+			zap.String("orientation", orientation))
+
+		// Per-user selection is served from the Redis indexes, which OIDC mode requires
+		selected := selectIndexedRandomImages(r.Context(), params, utils.RandomFilter{
+			Orientation: orientation,
+			UserID:      owner.ID,
+			Tags:        params.Tags,
+			ExcludeTags: params.ExcludeTags,
+			ImageIDs:    collection.imageIDs(),
+			Ratio:       params.Ratio,
+			Dimensions:  params.Dimensions,
+		})
+		if len(selected) == 0 {
+			errors.HandleError(w, errors.ErrNotFound, "No images found matching criteria", nil)
 			return
 		}
-		defer data.Body.Close()
 
-		// Serve the image
-		setImageResponseHeaders(w, contentType)
-		if _, err := io.Copy(w, data.Body); err != nil {
-			logger.Error("Failed to send image", zap.Error(err))
+		keys := make([]string, len(selected))
+		contentTypes := make([]string, len(selected))
+		for i, metadata := range selected {
+			keys[i], contentTypes[i] = resolveMetadataRendition(r, params, metadata)
 		}
+		deliverRandomImages(w, r, cfg, params, keys, contentTypes)
 	}
 }
 
-// serveS3Image is a helper function to serve images from S3
-func serveS3Image(s3Client *s3.Client, cfg *config.Config, w http.ResponseWriter, r *http.Request, key string, contentType string) {
-	data, err := s3Client.GetObject(r.Context(), &s3.GetObjectInput{
-		Bucket: aws.String(cfg.S3Bucket),
-		Key:    aws.String(key),
-	})
+// deliverRandomImage sends the selected image using the requested or configured mode.
+// Redirects point at the public or CDN URL of the rendition, so the bytes are served
+// from the edge rather than proxied; the redirect itself is never cached.
+func deliverRandomImage(w http.ResponseWriter, r *http.Request, cfg *config.Config, params *RandomQueryParams, key string, contentType string) {
+	if params.JSON {
+		writeRandomImageJSON(w, r, cfg, key)
+		return
+	}
 
-	if err != nil {
-		logger.Error("Failed to get image from S3", zap.String("key", key), zap.Error(err))
-		errors.HandleError(w, errors.ErrNotFound, "Image not found", err)
+	mode := params.Mode
+	if mode == "" {
+		mode = cfg.RandomMode
+	}
+
+	if mode == config.RandomModeRedirect {
+		setNoCacheHeaders(w)
+		http.Redirect(w, r, getPublicURL(key, cfg), http.StatusFound)
 		return
 	}
-	defer data.Body.Close()
 
-	setImageResponseHeaders(w, contentType)
-	if _, err := io.Copy(w, data.Body); err != nil {
-		logger.Error("Failed to send image", zap.Error(err))
+	serveRandomImage(w, r, key, contentType)
+}
+
+// writeRandomImageJSON describes the selected image and all its renditions so clients
+// can build their own markup, such as a <picture> element with a srcset
+func writeRandomImageJSON(w http.ResponseWriter, r *http.Request, cfg *config.Config, key string) {
+	resp := describeRandomImage(r, cfg, key)
+
+	setNoCacheHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Error("Failed to encode response", zap.Error(err))
 	}
 }
 
+// describeRandomImage builds the JSON description of a selected rendition
+func describeRandomImage(r *http.Request, cfg *config.Config, key string) RandomImageResponse {
+	id := utils.ImageIDFromKey(key)
+	resp := RandomImageResponse{
+		ID:   id,
+		URL:  getPublicURL(key, cfg),
+		Tags: []string{},
+	}
+
+	originalKey := key
+	if metadata, err := utils.MetadataManager.GetMetadata(r.Context(), id); err == nil {
+		resp.URLs = metadataURLs(metadata, cfg)
+		resp.Orientation = metadata.Orientation
+		resp.Width = metadata.Width
+		resp.Height = metadata.Height
+		resp.AspectRatio = metadata.Ratio()
+		resp.Format = metadata.Format
+		if metadata.Tags != nil {
+			resp.Tags = metadata.Tags
+		}
+		originalKey = metadata.Paths.Original
+	} else {
+		// Images found by scanning storage may have no metadata
+		resp.URLs = map[string]string{"original": resp.URL}
+		resp.Format = strings.TrimPrefix(path.Ext(key), ".")
+	}
+
+	// Dimensions are only read from storage for images uploaded before they were recorded
+	if resp.Width > 0 && resp.Height > 0 {
+		return resp
+	}
+	if width, height, err := utils.StoredImageDimensions(r.Context(), originalKey); err == nil {
+		resp.Width = width
+		resp.Height = height
+		resp.AspectRatio = utils.AspectRatio(width, height)
+		if resp.Orientation == "" {
+			resp.Orientation = determineImageOrientation(image.Config{Width: width, Height: height}, cfg)
+		}
+	} else {
+		logger.Debug("Failed to read image dimensions",
+			zap.String("key", originalKey),
+			zap.Error(err))
+	}
+
+	return resp
+}
+
+// serveRandomImage serves a stored image with the no-cache headers of random responses
+func serveRandomImage(w http.ResponseWriter, r *http.Request, key string, contentType string) {
+	setImageResponseHeaders(w, contentType)
+	serveImage(w, r, key, contentType, imageETag(r.Context(), key))
+}
+
 // LocalRandomImageHandler serves random images from local storage
 func LocalRandomImageHandler(cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Parse query parameters
 		params := parseRandomQueryParams(r)
+		if !checkRandomBias(w, params) {
+			return
+		}
+		collection, ok := loadRandomCollection(w, r, params)
+		if !ok {
+			return
+		}
 
 		// Determine device type and orientation
 		deviceType := utils.DetectDeviceType(r)
@@ -394,9 +936,12 @@ func LocalRandomImageHandler(cfg *config.Config) http.HandlerFunc {
 			orientation = "portrait" // Mobile gets portrait
 		}
 
-		// Override orientation if specified in params
+		// Override orientation if specified in params; aspect ratio filters otherwise
+		// accept images of any orientation
 		if params.Orientation != "" {
 			orientation = params.Orientation
+		} else if params.Ratio.IsSet() {
+			orientation = ""
 		}
 
 		logger.Info("Processing random image request",
@@ -405,108 +950,87 @@ func LocalRandomImageHandler(cfg *config.Config) http.HandlerFunc {
 			zap.String("orientation", orientation),
 			zap.String("device_type", deviceType))
 
-		// Find matching images
-		var matchingImages []*utils.ImageMetadata
-		var err error
-
-		// Use Redis for efficient filtering if available and filters are specified
-		if (len(params.Tags) > 0 || len(params.ExcludeTags) > 0) && utils.IsRedisMetadataStore() {
-			var candidateIDs []string
+		// Draw from the Redis random indexes when available
+		if utils.IsRedisMetadataStore() {
+			selected := selectIndexedRandomImages(r.Context(), params, utils.RandomFilter{
+				Orientation: orientation,
+				Tags:        params.Tags,
+				ExcludeTags: params.ExcludeTags,
+				ImageIDs:    collection.imageIDs(),
+				Ratio:       params.Ratio,
+				Dimensions:  params.Dimensions,
+			})
+			// The indexes hold every listed image, so an empty result needs no storage scan
+			if len(selected) == 0 {
+				errors.HandleError(w, errors.ErrNotFound, "No images found matching criteria", nil)
+				return
+			}
 
-			if len(params.Tags) > 0 {
-				// Get images that have ALL required tags
-				candidateIDs, err = utils.GetImagesByMultipleTags(context.Background(), params.Tags)
-				if err != nil {
-					logger.Error("Failed to get images by tags from Redis", zap.Error(err))
-				}
-			} else {
-				// Get all image IDs if only exclude filters are specified
-				candidateIDs, err = utils.GetAllImageIDs(context.Background())
-				if err != nil {
-					logger.Error("Failed to get all image IDs from Redis", zap.Error(err))
-				}
+			keys := make([]string, len(selected))
+			contentTypes := make([]string, len(selected))
+			for i, metadata := range selected {
+				logger.Debug("Selected random image",
+					zap.String("id", metadata.ID),
+					zap.String("orientation", metadata.Orientation))
+				keys[i], contentTypes[i] = resolveMetadataRendition(r, params, metadata)
 			}
+			deliverRandomImages(w, r, cfg, params, keys, contentTypes)
+			return
+		}
 
-			if err == nil && len(candidateIDs) > 0 {
-				// Filter by metadata
-				for _, id := range candidateIDs {
-					metadata, metaErr := utils.MetadataManager.GetMetadata(context.Background(), id)
-					if metaErr != nil {
-						continue
-					}
-
-					// Check tag matching
-					if !matchesTags(metadata.Tags, params.Tags, params.ExcludeTags) {
-						continue
-					}
-
-					// Check orientation
-					if metadata.Orientation == orientation {
-						matchingImages = append(matchingImages, metadata)
-					}
-				}
+		// Without Redis, find matching images by scanning the orientation directories
+		var matchingImages []*utils.ImageMetadata
+		logger.Debug("Looking for images in directory", zap.String("orientation", orientation))
 
-				logger.Info("Found matching images from Redis",
-					zap.Int("count", len(matchingImages)))
-			}
+		objects, err := listRandomOriginals(r.Context(), orientation)
+		if err != nil {
+			logger.Error("Failed to read directory",
+				zap.String("orientation", orientation),
+				zap.Error(err))
+			errors.HandleError(w, errors.ErrNotFound, "No images found", err)
+			return
 		}
 
-		// Fall back to directory scanning if Redis didn't work or no results
-		if len(matchingImages) == 0 {
-			// Read files from the orientation directory
-			originalDir := filepath.Join(cfg.ImageBasePath, "original", orientation)
-			logger.Debug("Looking for images in directory", zap.String("dir", originalDir))
-
-			files, err := os.ReadDir(originalDir)
-			if err != nil {
-				logger.Error("Failed to read directory",
-					zap.String("dir", originalDir),
-					zap.Error(err))
-				errors.HandleError(w, errors.ErrNotFound, "No images found", err)
-				return
+		// Process each file
+		for _, obj := range objects {
+			if !utils.IsImageFile(obj.Key) {
+				continue
 			}
 
-			// Process each file
-			for _, file := range files {
-				if file.IsDir() || !utils.IsImageFile(file.Name()) {
+			fileName := path.Base(obj.Key)
+			id := strings.TrimSuffix(fileName, path.Ext(fileName))
+			if !collection.contains(id) {
+				continue
+			}
+
+			// Apply tag, aspect ratio and dimension filtering if specified
+			if len(params.Tags) > 0 || len(params.ExcludeTags) > 0 || params.Ratio.IsSet() || params.Dimensions.IsSet() {
+				metadata, metaErr := utils.MetadataManager.GetMetadata(context.Background(), id)
+				if metaErr != nil {
+					// Skip if metadata not available
 					continue
 				}
 
-				id := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
-
-				// Apply tag filtering if specified
-				if len(params.Tags) > 0 || len(params.ExcludeTags) > 0 {
-					metadata, metaErr := utils.MetadataManager.GetMetadata(context.Background(), id)
-					if metaErr != nil {
-						// Skip if metadata not available
-						continue
-					}
-
-					if !matchesTags(metadata.Tags, params.Tags, params.ExcludeTags) {
-						continue
-					}
-
-					matchingImages = append(matchingImages, metadata)
-				} else {
-					// No tag filtering, create basic metadata
-					matchingImages = append(matchingImages, &utils.ImageMetadata{
-						ID:          id,
-						Orientation: orientation,
-						Paths: struct {
-							Original string `json:"original"`
-							WebP     string `json:"webp"`
-							AVIF     string `json:"avif"`
-						}{
-							Original: filepath.Join("original", orientation, file.Name()),
-						},
-					})
+				if !matchesTags(metadata.Tags, params.Tags, params.ExcludeTags) || !params.Ratio.Contains(metadata.Ratio()) ||
+					!params.Dimensions.Contains(metadata.Width, metadata.Height) {
+					continue
 				}
-			}
 
-			logger.Info("Found matching images from directory scan",
-				zap.Int("count", len(matchingImages)))
+				matchingImages = append(matchingImages, metadata)
+			} else {
+				// No tag filtering, create basic metadata
+				metadata := &utils.ImageMetadata{
+					ID:          id,
+					Orientation: path.Base(path.Dir(obj.Key)),
+				}
+				metadata.Paths.Original = obj.Key
+				matchingImages = append(matchingImages, metadata)
+			}
 		}
 
+		logger.Info("Found matching images from directory scan",
+			zap.Int("count", len(matchingImages)))
+
 		if len(matchingImages) == 0 {
 			logger.Warn("No images found matching criteria",
 				zap.Strings("tags", params.Tags),
@@ -516,83 +1040,104 @@ func LocalRandomImageHandler(cfg *config.Config) http.HandlerFunc {
 			return
 		}
 
-		// Select random image
-		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
-		randomIndex := rng.Intn(len(matchingImages))
-		selectedImage := matchingImages[randomIndex]
-		logger.Debug("Selected random image",
-			zap.String("id", selectedImage.ID),
-			zap.String("orientation", selectedImage.Orientation))
-
-		// Determine best format
-		bestFormat := detectBestFormat(r)
-		if params.Format != "" {
-			// Override with user preference if valid
-			switch params.Format {
-			case "avif", "webp", "original":
-				bestFormat = params.Format
-			}
+		// Storage listings come in no guaranteed order, so seeded selections need a stable order
+		if params.Seeded {
+			sort.Slice(matchingImages, func(i, j int) bool {
+				return matchingImages[i].ID < matchingImages[j].ID
+			})
 		}
-		logger.Debug("Best format for client", zap.String("format", bestFormat))
 
-		// Get image path and content type
-		var imagePath string
-		var contentType string
+		// Select random images
+		candidateIDs := make([]string, len(matchingImages))
+		for i, metadata := range matchingImages {
+			candidateIDs[i] = metadata.ID
+		}
+		weights := selectionWeights(r.Context(), params, candidateIDs)
+		picked := pickListedIndexes(newRandomSource(params), candidateIDs, params.Count, weights)
+		if len(picked) == 0 {
+			errors.HandleError(w, errors.ErrNotFound, "No images found matching criteria", nil)
+			return
+		}
 
-		// Check if the image is PNG for transparency preservation
-		isPNG := false
-		if selectedImage.Format == "png" {
-			isPNG = true
-		} else {
-			isPNG = strings.HasSuffix(strings.ToLower(selectedImage.Paths.Original), ".png")
+		keys := make([]string, len(picked))
+		contentTypes := make([]string, len(picked))
+		for i, index := range picked {
+			selectedImage := matchingImages[index]
+			logger.Debug("Selected random image",
+				zap.String("id", selectedImage.ID),
+				zap.String("orientation", selectedImage.Orientation))
+			keys[i], contentTypes[i] = resolveMetadataRendition(r, params, selectedImage)
 		}
 
-		// Handle PNG transparency preservation
-		if isPNG && bestFormat == FormatOriginal {
-			imagePath = filepath.Join(cfg.ImageBasePath, selectedImage.Paths.Original)
-			contentType = "image/png"
-			logger.Debug("Using original PNG for transparency", zap.String("path", imagePath))
-		} else {
-			// Use the appropriate format based on browser support and preference
-			switch bestFormat {
-			case FormatAVIF:
-				imagePath = filepath.Join(cfg.ImageBasePath, selectedImage.Orientation, "avif", selectedImage.ID+".avif")
-				contentType = "image/avif"
-			case FormatWebP:
-				imagePath = filepath.Join(cfg.ImageBasePath, selectedImage.Orientation, "webp", selectedImage.ID+".webp")
-				contentType = "image/webp"
-			default:
-				imagePath = filepath.Join(cfg.ImageBasePath, selectedImage.Paths.Original)
-				contentType = getContentType(FormatOriginal, imagePath)
-			}
+		// Stream or redirect to the images
+		deliverRandomImages(w, r, cfg, params, keys, contentTypes)
+	}
+}
 
-			logger.Debug("Using format and path",
-				zap.String("format", bestFormat),
-				zap.String("path", imagePath))
+// resolveMetadataRendition picks the stored rendition of a selected image best suited
+// to the client from its metadata, returning its key and content type
+func resolveMetadataRendition(r *http.Request, params *RandomQueryParams, selectedImage *utils.ImageMetadata) (string, string) {
+	// Determine best format
+	bestFormat := detectBestFormat(r)
+	if params.Format != "" {
+		// Override with user preference if valid
+		switch params.Format {
+		case "avif", "webp", "original":
+			bestFormat = params.Format
+		}
+	}
+	logger.Debug("Best format for client", zap.String("format", bestFormat))
+
+	// Get image path and content type
+	var imageKey string
+	var contentType string
+
+	// Check if the image is PNG for transparency preservation
+	isPNG := false
+	if selectedImage.Format == "png" {
+		isPNG = true
+	} else {
+		isPNG = strings.HasSuffix(strings.ToLower(selectedImage.Paths.Original), ".png")
+	}
 
-			// Check if file exists, fall back to original if needed
-			if _, err := os.Stat(imagePath); os.IsNotExist(err) && bestFormat != FormatOriginal {
-				logger.Info("Format not available, falling back to original",
-					zap.String("format", bestFormat))
-				imagePath = filepath.Join(cfg.ImageBasePath, selectedImage.Paths.Original)
-				contentType = getContentType(FormatOriginal, imagePath)
+	// Handle PNG transparency preservation
+	if isPNG && bestFormat == FormatOriginal {
+		imageKey = selectedImage.Paths.Original
+		contentType = "image/png"
+		logger.Debug("Using original PNG for transparency", zap.String("key", imageKey))
+	} else {
+		// Use the appropriate format based on browser support and preference
+		switch bestFormat {
+		case FormatAVIF:
+			imageKey = selectedImage.Paths.AVIF
+			if imageKey == "" {
+				// Images found by scanning storage only know the legacy layout
+				imageKey = path.Join(selectedImage.Orientation, "avif", selectedImage.ID+".avif")
 			}
+			contentType = "image/avif"
+		case FormatWebP:
+			imageKey = selectedImage.Paths.WebP
+			if imageKey == "" {
+				imageKey = path.Join(selectedImage.Orientation, "webp", selectedImage.ID+".webp")
+			}
+			contentType = "image/webp"
+		default:
+			imageKey = selectedImage.Paths.Original
+			contentType = getContentType(FormatOriginal, imageKey)
 		}
 
-		// Read and serve the image
-		imageData, err := os.ReadFile(imagePath)
-		if err != nil {
-			logger.Error("Failed to read image",
-				zap.String("path", imagePath),
-				zap.Error(err))
-			errors.HandleError(w, errors.ErrNotFound, "Image not found", err)
-			return
-		}
+		logger.Debug("Using format and path",
+			zap.String("format", bestFormat),
+			zap.String("key", imageKey))
 
-		// Set response headers and send image
-		setImageResponseHeaders(w, contentType)
-		if _, err := w.Write(imageData); err != nil {
-			logger.Error("Failed to send image", zap.Error(err))
+		// Check if file exists, fall back to original if needed
+		if _, err := utils.Storage.Stat(r.Context(), imageKey); err != nil && bestFormat != FormatOriginal {
+			logger.Info("Format not available, falling back to original",
+				zap.String("format", bestFormat))
+			imageKey = selectedImage.Paths.Original
+			contentType = getContentType(FormatOriginal, imageKey)
 		}
 	}
+
+	return imageKey, contentType
 }
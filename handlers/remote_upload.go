@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Yuri-NagaSaki/ImageFlow/config"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils/errors"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils/logger"
+	"go.uber.org/zap"
+)
+
+// RemoteUploadRequest represents the request body for uploading images from remote URLs
+type RemoteUploadRequest struct {
+	URLs          []string `json:"urls"`          // HTTP(S) URLs of the images to upload
+	Tags          []string `json:"tags"`          // Tags applied to every image
+	ExpiryMinutes int      `json:"expiryMinutes"` // Minutes until the images expire (0 = never)
+	Visibility    string   `json:"visibility"`    // public, unlisted or private (default public)
+	MergeTags     bool     `json:"mergeTags"`     // Merge tags into identical images already uploaded
+}
+
+// RemoteUploadHandler downloads images from remote URLs and stores them like regular uploads
+func RemoteUploadHandler(cfg *config.Config) http.HandlerFunc {
+	fetcher := utils.NewRemoteFetcher(cfg)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			errors.HandleError(w, errors.ErrInvalidParam, "Method not allowed", nil)
+			return
+		}
+
+		// Get user from context (set by RequireAuth middleware)
+		user, ok := GetUserFromContext(r.Context())
+		if !ok {
+			errors.HandleError(w, errors.ErrUnauthorized, "Authentication required", nil)
+			return
+		}
+
+		var req RemoteUploadRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			errors.HandleError(w, errors.ErrInvalidParam, "Invalid request body", nil)
+			logger.Warn("Failed to decode request body",
+				zap.Error(err))
+			return
+		}
+
+		if len(req.URLs) == 0 {
+			errors.HandleError(w, errors.ErrInvalidParam, "At least one URL is required", nil)
+			return
+		}
+		if len(req.URLs) > cfg.MaxUploadCount {
+			errors.HandleError(w, errors.ErrInvalidParam,
+				fmt.Sprintf("A request may contain at most %d URLs", cfg.MaxUploadCount), nil)
+			return
+		}
+		if req.ExpiryMinutes < 0 {
+			errors.HandleError(w, errors.ErrInvalidParam, "expiryMinutes must not be negative", nil)
+			return
+		}
+
+		// Validate tags with the same rules as image updates
+		update := UpdateImageRequest{Tags: &req.Tags}
+		var validated utils.ImageMetadata
+		if err := applyMetadataUpdate(&validated, &update); err != nil {
+			errors.HandleError(w, errors.ErrInvalidParam, err.Error(), nil)
+			return
+		}
+
+		visibility, err := utils.NormalizeVisibility(req.Visibility)
+		if err != nil {
+			errors.HandleError(w, errors.ErrInvalidParam, err.Error(), nil)
+			return
+		}
+
+		var expiryTime time.Time
+		if req.ExpiryMinutes > 0 {
+			expiryTime = time.Now().Add(time.Duration(req.ExpiryMinutes) * time.Minute)
+		}
+
+		ctx := &uploadContext{
+			r:          r,
+			user:       user,
+			expiryTime: expiryTime,
+			tags:       validated.Tags,
+			visibility: visibility,
+			mergeTags:  req.MergeTags,
+			cfg:        cfg,
+		}
+
+		logger.Info("Processing remote upload",
+			zap.Int("count", len(req.URLs)),
+			zap.String("user_id", user.ID))
+
+		// Download and process images concurrently, keeping results in request order
+		results := make([]UploadResult, len(req.URLs))
+		var wg sync.WaitGroup
+		for i, rawURL := range req.URLs {
+			wg.Add(1)
+			go func(i int, rawURL string) {
+				defer wg.Done()
+
+				data, filename, err := fetcher.Fetch(r.Context(), rawURL)
+				if err != nil {
+					logger.Warn("Failed to download remote image",
+						zap.String("url", rawURL),
+						zap.String("user_id", user.ID),
+						zap.Error(err))
+					results[i] = UploadResult{
+						Filename: rawURL,
+						Status:   "error",
+						Message:  err.Error(),
+					}
+				} else {
+					results[i] = processImage(ctx, filename, data)
+				}
+				results[i].SourceURL = rawURL
+			}(i, rawURL)
+		}
+		wg.Wait()
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{
+			"results": results,
+		}); err != nil {
+			logger.Error("Failed to encode response", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Yuri-NagaSaki/ImageFlow/config"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils/errors"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultSignedURLTTL = time.Hour
+	maxSignedURLTTL     = 7 * 24 * time.Hour
+)
+
+// SignURLRequest represents the request body for minting a signed image URL
+type SignURLRequest struct {
+	ID        string `json:"id"`        // Image ID
+	Variant   string `json:"variant"`   // original, webp, avif or a thumbnail variant (default original)
+	ExpiresIn int    `json:"expiresIn"` // Lifetime in seconds (default 3600, max 7 days)
+}
+
+// SignURLResponse represents the response containing a signed image URL
+type SignURLResponse struct {
+	Success   bool   `json:"success"`   // Whether the operation was successful
+	URL       string `json:"url"`       // Signed URL path
+	ExpiresAt string `json:"expiresAt"` // Expiry timestamp (RFC3339)
+}
+
+// signedImageURLs replaces the public URLs of a private image with signed URLs,
+// or with the owner-only proxy when URL signing is not configured
+func signedImageURLs(cfg *config.Config, id string, urls map[string]string) map[string]string {
+	signed := make(map[string]string, len(urls))
+	expiresAt := time.Now().Add(defaultSignedURLTTL)
+	for variant := range urls {
+		signedURL, err := utils.SignImageURL(cfg, id, variant, expiresAt)
+		if err != nil {
+			signedURL = fmt.Sprintf("/api/images/%s/file?variant=%s", id, variant)
+		}
+		signed[variant] = signedURL
+	}
+	return signed
+}
+
+// serveStoredImage serves a stored object with a content type derived from its key,
+// honoring conditional and range requests
+func serveStoredImage(w http.ResponseWriter, r *http.Request, key string, cacheControl string) {
+	w.Header().Set("Cache-Control", cacheControl)
+	serveImage(w, r, key, utils.ContentTypeForKey(key), imageETag(r.Context(), key))
+}
+
+// SignedImageHandler serves an image after verifying the HMAC signature and expiry in its URL
+func SignedImageHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			errors.HandleError(w, errors.ErrInvalidParam, "Method not allowed", nil)
+			return
+		}
+
+		id := r.PathValue("id")
+		query := r.URL.Query()
+		variant := query.Get("variant")
+		if variant == "" {
+			variant = "original"
+		}
+
+		if err := utils.VerifyImageSignature(cfg, id, variant, query.Get("exp"), query.Get("sig")); err != nil {
+			errors.HandleError(w, errors.ErrForbidden, "Invalid or expired signature", nil)
+			logger.Debug("Rejected signed URL",
+				zap.String("image_id", id),
+				zap.String("variant", variant),
+				zap.Error(err))
+			return
+		}
+
+		metadata, err := utils.MetadataManager.GetMetadata(r.Context(), id)
+		if err != nil {
+			errors.HandleError(w, errors.ErrNotFound, "Image not found", nil)
+			return
+		}
+
+		key, ok := metadata.VariantPath(variant)
+		if !ok {
+			errors.HandleError(w, errors.ErrNotFound, "Image variant not found", nil)
+			return
+		}
+
+		// Shared caches must not keep a copy beyond the lifetime of the signature
+		serveStoredImage(w, r, key, "private, no-store")
+	}
+}
+
+// SignURLHandler mints signed, expiring URLs for images owned by the caller
+func SignURLHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			errors.HandleError(w, errors.ErrInvalidParam, "Method not allowed", nil)
+			return
+		}
+
+		user, ok := GetUserFromContext(r.Context())
+		if !ok {
+			errors.HandleError(w, errors.ErrUnauthorized, "Authentication required", nil)
+			return
+		}
+
+		var req SignURLRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			errors.HandleError(w, errors.ErrInvalidParam, "Invalid request body", nil)
+			return
+		}
+		if req.ID == "" {
+			errors.HandleError(w, errors.ErrInvalidParam, "Image ID is required", nil)
+			return
+		}
+
+		ttl := defaultSignedURLTTL
+		if req.ExpiresIn > 0 {
+			ttl = time.Duration(req.ExpiresIn) * time.Second
+		}
+		if ttl > maxSignedURLTTL {
+			errors.HandleError(w, errors.ErrInvalidParam, "expiresIn must not exceed 7 days", nil)
+			return
+		}
+
+		// Verify image ownership (except for API key users)
+		if cfg.AuthType == config.AuthTypeOIDC && user.ID != "api_key_user" {
+			if err := utils.MetadataManager.VerifyImageOwnership(r.Context(), req.ID, user.ID); err != nil {
+				errors.HandleError(w, errors.ErrForbidden, "You don't have permission to access this image", nil)
+				return
+			}
+		}
+
+		metadata, err := utils.MetadataManager.GetMetadata(r.Context(), req.ID)
+		if err != nil {
+			errors.HandleError(w, errors.ErrNotFound, "Image not found", nil)
+			return
+		}
+		if req.Variant == "" {
+			req.Variant = "original"
+		}
+		if _, ok := metadata.VariantPath(req.Variant); !ok {
+			errors.HandleError(w, errors.ErrInvalidParam, "Unknown image variant", nil)
+			return
+		}
+
+		expiresAt := time.Now().Add(ttl)
+		signedURL, err := utils.SignImageURL(cfg, req.ID, req.Variant, expiresAt)
+		if err != nil {
+			errors.HandleError(w, errors.ErrInternal, "URL signing is not configured", nil)
+			logger.Error("Failed to sign image URL", zap.Error(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(SignURLResponse{
+			Success:   true,
+			URL:       signedURL,
+			ExpiresAt: expiresAt.Format(time.RFC3339),
+		}); err != nil {
+			logger.Error("Failed to encode response", zap.Error(err))
+		}
+
+		logger.Debug("Signed URL issued",
+			zap.String("image_id", req.ID),
+			zap.String("variant", req.Variant),
+			zap.String("user_id", user.ID),
+			zap.Duration("ttl", ttl))
+	}
+}
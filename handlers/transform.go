@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Yuri-NagaSaki/ImageFlow/config"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils/errors"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils/logger"
+	"go.uber.org/zap"
+)
+
+// parseTransformParams extracts transform options from query parameters
+func parseTransformParams(r *http.Request) (utils.TransformOptions, error) {
+	query := r.URL.Query()
+	opts := utils.TransformOptions{
+		Fit:     query.Get("fit"),
+		Gravity: query.Get("gravity"),
+		Format:  strings.ToLower(query.Get("format")),
+	}
+
+	intParams := map[string]*int{
+		"w": &opts.Width,
+		"h": &opts.Height,
+		"q": &opts.Quality,
+	}
+	for name, ptr := range intParams {
+		if val := query.Get(name); val != "" {
+			num, err := strconv.Atoi(val)
+			if err != nil {
+				return opts, fmt.Errorf("invalid %s parameter: %s", name, val)
+			}
+			*ptr = num
+		}
+	}
+
+	return opts, nil
+}
+
+// negotiateTransformFormat picks an output format from the Accept header
+// when the client did not request one explicitly
+func negotiateTransformFormat(r *http.Request, originalFormat string) string {
+	switch detectBestFormat(r) {
+	case FormatAVIF:
+		return "avif"
+	case FormatWebP:
+		return "webp"
+	}
+	if utils.IsSupportedTransformFormat(originalFormat) {
+		return originalFormat
+	}
+	return "jpeg"
+}
+
+// TransformHandler serves resized, cropped and re-encoded variants derived from a stored original
+func TransformHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			errors.HandleError(w, errors.ErrInvalidParam, "Method not allowed", nil)
+			logger.Warn("Invalid request method",
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path))
+			return
+		}
+
+		id := r.PathValue("id")
+		if id == "" {
+			errors.HandleError(w, errors.ErrInvalidParam, "Image ID is required", nil)
+			return
+		}
+
+		opts, err := parseTransformParams(r)
+		if err != nil {
+			errors.HandleError(w, errors.ErrInvalidParam, err.Error(), nil)
+			return
+		}
+
+		metadata, err := utils.MetadataManager.GetMetadata(r.Context(), id)
+		if err != nil {
+			errors.HandleError(w, errors.ErrNotFound, "Image not found", nil)
+			logger.Debug("Transform requested for unknown image",
+				zap.String("image_id", id),
+				zap.Error(err))
+			return
+		}
+
+		// Transforms are served publicly, so private images are not exposed through them
+		if metadata.IsPrivate() {
+			errors.HandleError(w, errors.ErrNotFound, "Image not found", nil)
+			return
+		}
+
+		negotiated := opts.Format == ""
+		// Animated GIFs are only served untouched, so an explicit conversion cannot be honoured
+		if metadata.Format == "gif" && !negotiated {
+			errors.HandleError(w, errors.ErrInvalidParam, "GIF images cannot be converted to another format", nil)
+			return
+		}
+		if negotiated {
+			opts.Format = negotiateTransformFormat(r, metadata.Format)
+		}
+		if err := opts.Validate(); err != nil {
+			errors.HandleError(w, errors.ErrInvalidParam, err.Error(), nil)
+			return
+		}
+		// Resolve the default quality so equivalent requests share one cached variant
+		if opts.Quality == 0 {
+			opts.Quality = cfg.ImageQuality
+		}
+
+		// Variants are deterministic, so a matching validator is answered before any work is done
+		etagKey := utils.VariantKey(metadata.ID, opts)
+		if metadata.Format == "gif" {
+			etagKey = metadata.Paths.Original
+		}
+		etag := metadata.ETag(etagKey)
+		if notModified(r, etag) {
+			setTransformCacheHeaders(w, negotiated)
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		data, contentType, cacheStatus, err := loadTransformedImage(r.Context(), metadata, opts, cfg)
+		if err != nil {
+			if err == errOriginalUnavailable {
+				errors.HandleError(w, errors.ErrNotFound, "Image not found", nil)
+			} else {
+				errors.HandleError(w, errors.ErrImageProcess, "Failed to transform image", nil)
+			}
+			return
+		}
+
+		setTransformCacheHeaders(w, negotiated)
+		w.Header().Set("X-Cache", cacheStatus)
+		serveImageData(w, r, data, contentType, etag, metadata.UploadTime)
+
+		logger.Debug("Transformed image served",
+			zap.String("image_id", id),
+			zap.Int("width", opts.Width),
+			zap.Int("height", opts.Height),
+			zap.String("fit", opts.Fit),
+			zap.String("format", opts.Format),
+			zap.String("cache", cacheStatus),
+			zap.Int("size", len(data)))
+	}
+}
+
+// setTransformCacheHeaders marks transformed images as immutable, varying by Accept when the format was negotiated
+func setTransformCacheHeaders(w http.ResponseWriter, negotiated bool) {
+	w.Header().Set("Cache-Control", "public, max-age=31536000")
+	if negotiated {
+		w.Header().Set("Vary", "Accept")
+	}
+}
+
+// errOriginalUnavailable signals that the source image could not be read from storage
+var errOriginalUnavailable = fmt.Errorf("original image unavailable")
+
+// loadTransformedImage returns the requested variant from the variant cache, or
+// derives it from the original and caches the result
+func loadTransformedImage(ctx context.Context, metadata *utils.ImageMetadata, opts utils.TransformOptions, cfg *config.Config) ([]byte, string, string, error) {
+	// Animated GIFs are served untouched, matching the upload conversion behaviour
+	if metadata.Format == "gif" {
+		data, err := utils.Storage.Get(ctx, metadata.Paths.Original)
+		if err != nil {
+			logger.Error("Failed to read original image",
+				zap.String("image_id", metadata.ID),
+				zap.String("key", metadata.Paths.Original),
+				zap.Error(err))
+			return nil, "", "", errOriginalUnavailable
+		}
+		return data, "image/gif", "BYPASS", nil
+	}
+
+	key := utils.VariantKey(metadata.ID, opts)
+	if data, ok := utils.Variants.Get(ctx, metadata, key); ok {
+		return data, opts.MimeType(), "HIT", nil
+	}
+
+	original, err := utils.Storage.Get(ctx, metadata.Paths.Original)
+	if err != nil {
+		logger.Error("Failed to read original image",
+			zap.String("image_id", metadata.ID),
+			zap.String("key", metadata.Paths.Original),
+			zap.Error(err))
+		return nil, "", "", errOriginalUnavailable
+	}
+
+	data, err := utils.TransformWithBimg(original, opts, cfg)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	if err := utils.Variants.Put(ctx, metadata.ID, key, data); err != nil {
+		logger.Warn("Failed to cache transformed variant",
+			zap.String("image_id", metadata.ID),
+			zap.String("key", key),
+			zap.Error(err))
+	}
+
+	return data, opts.MimeType(), "MISS", nil
+}
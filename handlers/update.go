@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Yuri-NagaSaki/ImageFlow/config"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils/errors"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils/logger"
+	"go.uber.org/zap"
+)
+
+// UpdateImageRequest represents the request body for updating an image.
+// Fields left out of the request are not changed.
+type UpdateImageRequest struct {
+	Tags         *[]string `json:"tags"`         // Replacement tag list
+	OriginalName *string   `json:"originalName"` // Display name of the image
+	ExpiryTime   *string   `json:"expiryTime"`   // Expiry timestamp (RFC3339), empty string removes the expiry
+	Visibility   *string   `json:"visibility"`   // public, unlisted or private
+	Weight       *float64  `json:"weight"`       // Relative weight in random selection, 0 restores the default of 1
+}
+
+// UpdateImageResponse represents the response after updating an image
+type UpdateImageResponse struct {
+	Success bool                 `json:"success"` // Whether the operation was successful
+	Message string               `json:"message"` // Description of the result
+	Image   *utils.ImageMetadata `json:"image"`   // Updated image metadata
+}
+
+// UpdateImageHandler returns a handler for updating the settings of an existing image
+func UpdateImageHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			errors.HandleError(w, errors.ErrInvalidParam, "Method not allowed", nil)
+			logger.Warn("Invalid request method",
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path))
+			return
+		}
+
+		// Get user from context (set by RequireAuth middleware)
+		user, ok := GetUserFromContext(r.Context())
+		if !ok {
+			errors.HandleError(w, errors.ErrUnauthorized, "Authentication required", nil)
+			return
+		}
+
+		id := r.PathValue("id")
+		if id == "" {
+			errors.HandleError(w, errors.ErrInvalidParam, "Image ID is required", nil)
+			return
+		}
+
+		var req UpdateImageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			errors.HandleError(w, errors.ErrInvalidParam, "Invalid request body", nil)
+			logger.Warn("Failed to decode request body",
+				zap.Error(err))
+			return
+		}
+
+		// Verify image ownership (except for API key users)
+		if cfg.AuthType == config.AuthTypeOIDC && user.ID != "api_key_user" {
+			if err := utils.MetadataManager.VerifyImageOwnership(r.Context(), id, user.ID); err != nil {
+				errors.HandleError(w, errors.ErrForbidden, "You don't have permission to update this image", nil)
+				logger.Warn("User attempted to update image they don't own",
+					zap.String("user_id", user.ID),
+					zap.String("image_id", id),
+					zap.Error(err))
+				return
+			}
+		}
+
+		metadata, err := utils.MetadataManager.GetMetadata(r.Context(), id)
+		if err != nil {
+			errors.HandleError(w, errors.ErrNotFound, "Image not found", nil)
+			return
+		}
+
+		if err := applyMetadataUpdate(metadata, &req); err != nil {
+			errors.HandleError(w, errors.ErrInvalidParam, err.Error(), nil)
+			return
+		}
+
+		previousVisibility := metadata.Visibility
+		visibilityChanged := false
+		if req.Visibility != nil {
+			visibility, err := utils.NormalizeVisibility(*req.Visibility)
+			if err != nil {
+				errors.HandleError(w, errors.ErrInvalidParam, err.Error(), nil)
+				return
+			}
+			if visibility != metadata.Visibility {
+				visibilityChanged = true
+				metadata.Visibility = visibility
+			}
+		}
+
+		// Stored objects are updated before the metadata, so a failure never leaves an
+		// image recorded as private while its files are still publicly readable
+		if visibilityChanged {
+			if err := utils.ApplyVisibility(r.Context(), metadata); err != nil {
+				revertVisibility(r, metadata, previousVisibility)
+				errors.HandleError(w, errors.ErrInternal, "Failed to update access to stored files", nil)
+				logger.Error("Failed to apply image visibility",
+					zap.String("image_id", id),
+					zap.Error(err))
+				return
+			}
+
+			// Cached transform variants are publicly readable, drop them for private images
+			if metadata.IsPrivate() {
+				utils.Variants.Purge(r.Context(), metadata)
+				metadata.Variants = nil
+			}
+		}
+
+		if err := utils.MetadataManager.SaveMetadata(r.Context(), metadata); err != nil {
+			if visibilityChanged {
+				revertVisibility(r, metadata, previousVisibility)
+			}
+			errors.HandleError(w, errors.ErrMetadata, "Failed to save image metadata", nil)
+			logger.Error("Failed to save updated metadata",
+				zap.String("image_id", id),
+				zap.Error(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(UpdateImageResponse{
+			Success: true,
+			Message: "Image updated successfully",
+			Image:   metadata,
+		}); err != nil {
+			logger.Error("Failed to encode response",
+				zap.String("image_id", id),
+				zap.Error(err))
+		}
+
+		logger.Info("Image updated",
+			zap.String("image_id", id),
+			zap.String("user_id", user.ID),
+			zap.Strings("tags", metadata.Tags),
+			zap.Time("expiry_time", metadata.ExpiryTime),
+			zap.String("visibility", metadata.Visibility))
+	}
+}
+
+// revertVisibility restores the access of stored objects to the visibility the image
+// metadata still records after a failed update
+func revertVisibility(r *http.Request, metadata *utils.ImageMetadata, visibility string) {
+	metadata.Visibility = visibility
+	if err := utils.ApplyVisibility(r.Context(), metadata); err != nil {
+		logger.Error("Failed to revert image visibility",
+			zap.String("image_id", metadata.ID),
+			zap.Error(err))
+	}
+}
+
+// applyMetadataUpdate validates the tag, name, expiry and weight fields of an update request
+// and applies them to the metadata
+func applyMetadataUpdate(metadata *utils.ImageMetadata, req *UpdateImageRequest) error {
+	if req.Tags != nil {
+		tags := utils.NormalizeTags(*req.Tags)
+		for _, tag := range tags {
+			if strings.Contains(tag, ",") {
+				return fmt.Errorf("tags must not contain commas: %s", tag)
+			}
+		}
+		metadata.Tags = tags
+	}
+
+	if req.OriginalName != nil {
+		name := strings.TrimSpace(*req.OriginalName)
+		if name == "" {
+			return fmt.Errorf("originalName must not be empty")
+		}
+		metadata.OriginalName = name
+	}
+
+	if req.ExpiryTime != nil {
+		if *req.ExpiryTime == "" {
+			metadata.ExpiryTime = time.Time{}
+		} else {
+			expiryTime, err := time.Parse(time.RFC3339, *req.ExpiryTime)
+			if err != nil {
+				return fmt.Errorf("invalid expiryTime, expected RFC3339: %s", *req.ExpiryTime)
+			}
+			if !expiryTime.After(time.Now()) {
+				return fmt.Errorf("expiryTime must be in the future")
+			}
+			metadata.ExpiryTime = expiryTime
+		}
+	}
+
+	if req.Weight != nil {
+		// Only the Redis random indexes take weights into account
+		if !utils.IsRedisMetadataStore() {
+			return fmt.Errorf("weight requires the Redis metadata store")
+		}
+		if *req.Weight < 0 || *req.Weight > utils.MaxRandomWeight {
+			return fmt.Errorf("weight must be between 0 and %d", utils.MaxRandomWeight)
+		}
+		metadata.Weight = *req.Weight
+	}
+
+	return nil
+}
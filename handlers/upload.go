@@ -1,12 +1,16 @@
 package handlers
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"image"
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
+	"io"
 	"mime/multipart"
 	"net/http"
 	"strconv"
@@ -23,6 +27,7 @@ import (
 
 // UploadResult represents the result of an image upload
 type UploadResult struct {
+	ID          string            `json:"id,omitempty"`
 	Filename    string            `json:"filename"`
 	Status      string            `json:"status"`
 	Message     string            `json:"message"`
@@ -31,6 +36,9 @@ type UploadResult struct {
 	URLs        map[string]string `json:"urls,omitempty"`
 	ExpiryTime  string            `json:"expiryTime,omitempty"`
 	Tags        []string          `json:"tags,omitempty"`
+	Visibility  string            `json:"visibility,omitempty"`
+	Duplicate   bool              `json:"duplicate,omitempty"` // Identical bytes were already uploaded by this user
+	SourceURL   string            `json:"sourceUrl,omitempty"` // URL the image was downloaded from (remote uploads only)
 }
 
 // getPublicURL constructs a public-facing URL for accessing an image
@@ -47,17 +55,14 @@ func getPublicURL(key string, cfg *config.Config) string {
 	return fmt.Sprintf("%s/%s/%s", endpoint, cfg.S3Bucket, key)
 }
 
-// determineImageOrientation classifies an image as landscape or portrait
-// Square images and portrait images are classified as portrait
-func determineImageOrientation(img image.Config) string {
-	if img.Width > img.Height {
-		return "landscape"
-	}
-	return "portrait"
+// determineImageOrientation classifies an image as landscape, portrait or square,
+// where square allows the configured difference between the sides
+func determineImageOrientation(img image.Config, cfg *config.Config) string {
+	return utils.ClassifyOrientation(img.Width, img.Height, cfg.SquareTolerance)
 }
 
-// processImage handles the processing of a single image file
-func processImage(ctx *uploadContext, fileHeader *multipart.FileHeader) UploadResult {
+// processUploadedFile reads a multipart file and processes it as an image
+func processUploadedFile(ctx *uploadContext, fileHeader *multipart.FileHeader) UploadResult {
 	file, err := fileHeader.Open()
 	if err != nil {
 		logger.Error("打开上传文件失败",
@@ -71,35 +76,46 @@ func processImage(ctx *uploadContext, fileHeader *multipart.FileHeader) UploadRe
 	}
 	defer file.Close()
 
-	// Read image configuration to determine orientation
-	img, _, err := image.DecodeConfig(file)
-	if err != nil {
+	// Decoding, metadata stripping and the conversions all work on the whole file in
+	// memory, so the original is read into a single buffer of its final size
+	data := make([]byte, fileHeader.Size)
+	if _, err := io.ReadFull(file, data); err != nil {
 		return UploadResult{
 			Filename: fileHeader.Filename,
 			Status:   "error",
-			Message:  fmt.Sprintf("Error reading image configuration: %v", err),
+			Message:  fmt.Sprintf("Error reading file: %v", err),
 		}
 	}
-	orientation := determineImageOrientation(img)
 
-	// Reset file pointer
-	if _, err := file.Seek(0, 0); err != nil {
+	return processImage(ctx, fileHeader.Filename, data)
+}
+
+// processImage stores a single image with all its renditions and metadata.
+// originalName is the name reported back to the client and kept in metadata.
+func processImage(ctx *uploadContext, originalName string, data []byte) UploadResult {
+	// Read image configuration to determine orientation
+	img, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
 		return UploadResult{
-			Filename: fileHeader.Filename,
+			Filename: originalName,
 			Status:   "error",
-			Message:  fmt.Sprintf("Error resetting file pointer: %v", err),
+			Message:  fmt.Sprintf("Error reading image configuration: %v", err),
 		}
 	}
 
-	// Read file content
-	data := make([]byte, fileHeader.Size)
-	if _, err := file.Read(data); err != nil {
-		return UploadResult{
-			Filename: fileHeader.Filename,
-			Status:   "error",
-			Message:  fmt.Sprintf("Error reading file: %v", err),
-		}
+	// Camera data is informational, a malformed EXIF block does not fail the upload
+	exif, err := utils.ParseEXIF(data)
+	if err != nil {
+		logger.Debug("Failed to parse EXIF data",
+			zap.String("filename", originalName),
+			zap.Error(err))
+	}
+
+	// Classify and record the image as displayed, which may be turned by its EXIF orientation
+	if exif != nil {
+		img.Width, img.Height = utils.OrientedSize(img.Width, img.Height, exif.Orientation)
 	}
+	orientation := determineImageOrientation(img, ctx.cfg)
 
 	// Generate unique filename
 	timestamp := time.Now().Format("20060102_150405")
@@ -110,19 +126,61 @@ func processImage(ctx *uploadContext, fileHeader *multipart.FileHeader) UploadRe
 	imgFormat, err := utils.DetectImageFormat(data)
 	if err != nil {
 		return UploadResult{
-			Filename: fileHeader.Filename,
+			Filename: originalName,
 			Status:   "error",
 			Message:  fmt.Sprintf("Error detecting image format: %v", err),
 		}
 	}
 
+	// Identical bytes uploaded by the same user with the same visibility and expiry
+	// resolve to the existing image
+	hashSum := sha256.Sum256(data)
+	contentHash := hex.EncodeToString(hashSum[:])
+	if existing, err := utils.MetadataManager.FindByContentHash(ctx.r.Context(), ctx.user.ID, contentHash); err != nil {
+		logger.Warn("Failed to look up content hash",
+			zap.String("filename", originalName),
+			zap.Error(err))
+	} else if existing != nil && sameUploadSettings(ctx, existing) && originalStored(ctx, existing) {
+		return duplicateUploadResult(ctx, originalName, existing)
+	}
+
+	// Turn the pixels upright so stored files and conversions no longer depend on the
+	// orientation tag, which metadata stripping may remove
+	if exif != nil && exif.Orientation > 1 {
+		if oriented, err := utils.AutoOrientWithBimg(data, exif.Orientation); err != nil {
+			logger.Warn("Failed to apply EXIF orientation",
+				zap.String("filename", originalName),
+				zap.Int("orientation", exif.Orientation),
+				zap.Error(err))
+		} else {
+			data = oriented
+			exif.Orientation = 1
+		}
+	}
+
+	// Remove metadata the configured policy keeps out of stored files, and out of the
+	// recorded camera data. The content hash above stays that of the uploaded bytes so
+	// re-uploads are still recognised.
+	data = utils.StripMetadata(data, ctx.cfg.MetadataStrip)
+	switch ctx.cfg.MetadataStrip {
+	case config.MetadataStripAll:
+		exif = nil
+	case config.MetadataStripGPS:
+		if exif != nil {
+			exif.GPS = nil
+			if *exif == (utils.EXIFData{}) {
+				exif = nil
+			}
+		}
+	}
+
 	// Create user storage paths manager
 	userPaths := utils.NewUserStoragePaths(ctx.user.ID, ctx.cfg)
 
 	// Ensure user directories exist
 	if err := userPaths.EnsureUserDirectories(); err != nil {
 		return UploadResult{
-			Filename: fileHeader.Filename,
+			Filename: originalName,
 			Status:   "error",
 			Message:  fmt.Sprintf("Error creating user directories: %v", err),
 		}
@@ -136,16 +194,19 @@ func processImage(ctx *uploadContext, fileHeader *multipart.FileHeader) UploadRe
 		originalKey = userPaths.GetOriginalPath(filename+imgFormat.Extension, orientation)
 	}
 
-	if err := utils.Storage.Store(ctx.r.Context(), originalKey, data); err != nil {
+	// Private images are stored without public access
+	private := ctx.visibility == utils.VisibilityPrivate
+
+	if err := utils.PutImageObject(ctx.r.Context(), originalKey, bytes.NewReader(data), int64(len(data)), imgFormat.MimeType, private); err != nil {
 		return UploadResult{
-			Filename: fileHeader.Filename,
+			Filename: originalName,
 			Status:   "error",
 			Message:  fmt.Sprintf("Error storing original file: %v", err),
 		}
 	}
 	logger.Info("Original image stored",
 		zap.String("key", originalKey),
-		zap.String("filename", fileHeader.Filename),
+		zap.String("filename", originalName),
 		zap.String("format", imgFormat.Format),
 		zap.Int("size", len(data)))
 
@@ -155,24 +216,45 @@ func processImage(ctx *uploadContext, fileHeader *multipart.FileHeader) UploadRe
 	var webpURL, avifURL string
 	var wg sync.WaitGroup
 
+	// Thumbnails are keyed by variant name, e.g. thumb_150
+	var thumbMu sync.Mutex
+	thumbnailPaths := make(map[string]string)
+	thumbnailSizes := make(map[string]int64)
+
+	var perceptualHash string
+
 	if imgFormat.Format != "gif" {
+		// Perceptual hash for near-duplicate detection
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hash, err := utils.PerceptualHash(data)
+			if err != nil {
+				logger.Warn("Perceptual hash computation failed",
+					zap.String("filename", originalName),
+					zap.Error(err))
+				return
+			}
+			perceptualHash = hash
+		}()
+
 		// WebP conversion
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			logger.Debug("Starting WebP conversion",
-				zap.String("filename", fileHeader.Filename))
+				zap.String("filename", originalName))
 
 			webpData, err := utils.ConvertToWebPWithBimg(data, ctx.cfg)
 			if err != nil {
 				logger.Error("WebP conversion failed",
-					zap.String("filename", fileHeader.Filename),
+					zap.String("filename", originalName),
 					zap.Error(err))
 				return
 			}
 
 			webpKey := userPaths.GetWebPPath(filename, orientation)
-			if err := utils.Storage.Store(ctx.r.Context(), webpKey, webpData); err != nil {
+			if err := utils.PutImageObject(ctx.r.Context(), webpKey, bytes.NewReader(webpData), int64(len(webpData)), "image/webp", private); err != nil {
 				logger.Error("Failed to store WebP image",
 					zap.String("key", webpKey),
 					zap.Error(err))
@@ -192,18 +274,18 @@ func processImage(ctx *uploadContext, fileHeader *multipart.FileHeader) UploadRe
 		go func() {
 			defer wg.Done()
 			logger.Debug("Starting AVIF conversion",
-				zap.String("filename", fileHeader.Filename))
+				zap.String("filename", originalName))
 
 			avifData, err := utils.ConvertToAVIFWithBimg(data, ctx.cfg)
 			if err != nil {
 				logger.Error("AVIF conversion failed",
-					zap.String("filename", fileHeader.Filename),
+					zap.String("filename", originalName),
 					zap.Error(err))
 				return
 			}
 
 			avifKey := userPaths.GetAVIFPath(filename, orientation)
-			if err := utils.Storage.Store(ctx.r.Context(), avifKey, avifData); err != nil {
+			if err := utils.PutImageObject(ctx.r.Context(), avifKey, bytes.NewReader(avifData), int64(len(avifData)), "image/avif", private); err != nil {
 				logger.Error("Failed to store AVIF image",
 					zap.String("key", avifKey),
 					zap.Error(err))
@@ -218,10 +300,45 @@ func processImage(ctx *uploadContext, fileHeader *multipart.FileHeader) UploadRe
 				zap.Int64("size", avifSize))
 		}()
 
+		// Thumbnail generation
+		for _, size := range ctx.cfg.ThumbnailSizes {
+			wg.Add(1)
+			go func(size int) {
+				defer wg.Done()
+				variant := utils.ThumbnailVariant(size)
+
+				thumbData, err := utils.GenerateThumbnail(data, size, ctx.cfg)
+				if err != nil {
+					logger.Error("Thumbnail generation failed",
+						zap.String("filename", originalName),
+						zap.Int("size", size),
+						zap.Error(err))
+					return
+				}
+
+				thumbKey := userPaths.GetThumbnailPath(filename, orientation, size)
+				if err := utils.PutImageObject(ctx.r.Context(), thumbKey, bytes.NewReader(thumbData), int64(len(thumbData)), "image/webp", private); err != nil {
+					logger.Error("Failed to store thumbnail",
+						zap.String("key", thumbKey),
+						zap.Error(err))
+					return
+				}
+
+				thumbMu.Lock()
+				thumbnailPaths[variant] = thumbKey
+				thumbnailSizes[variant] = int64(len(thumbData))
+				thumbMu.Unlock()
+				logger.Debug("Thumbnail generated",
+					zap.String("key", thumbKey),
+					zap.Int("size", size),
+					zap.Int("bytes", len(thumbData)))
+			}(size)
+		}
+
 		wg.Wait()
 	} else {
 		logger.Info("Skipping conversions for GIF image",
-			zap.String("filename", fileHeader.Filename))
+			zap.String("filename", originalName))
 		// For GIF, all formats use the same file
 		webpSize = originalSize
 		avifSize = originalSize
@@ -233,12 +350,12 @@ func processImage(ctx *uploadContext, fileHeader *multipart.FileHeader) UploadRe
 	// Set WebP and AVIF URLs with defaults if conversion failed
 	if webpURL == "" {
 		logger.Debug("Using original URL for WebP",
-			zap.String("filename", fileHeader.Filename))
+			zap.String("filename", originalName))
 		webpURL = originalURL
 	}
 	if avifURL == "" {
 		logger.Debug("Using original URL for AVIF",
-			zap.String("filename", fileHeader.Filename))
+			zap.String("filename", originalName))
 		avifURL = originalURL
 	}
 
@@ -248,14 +365,21 @@ func processImage(ctx *uploadContext, fileHeader *multipart.FileHeader) UploadRe
 	}
 
 	metadata := &utils.ImageMetadata{
-		ID:           imageID,
-		UserID:       ctx.user.ID,
-		OriginalName: fileHeader.Filename,
-		UploadTime:   time.Now(),
-		Format:       imgFormat.Format,
-		Orientation:  orientation,
-		Tags:         ctx.tags,
-		Sizes:        make(map[string]int64),
+		ID:             imageID,
+		UserID:         ctx.user.ID,
+		OriginalName:   originalName,
+		UploadTime:     time.Now(),
+		Format:         imgFormat.Format,
+		Orientation:    orientation,
+		Width:          img.Width,
+		Height:         img.Height,
+		AspectRatio:    utils.AspectRatio(img.Width, img.Height),
+		EXIF:           exif,
+		Tags:           ctx.tags,
+		Visibility:     ctx.visibility,
+		ContentHash:    contentHash,
+		PerceptualHash: perceptualHash,
+		Sizes:          make(map[string]int64),
 	}
 
 	if !ctx.expiryTime.IsZero() {
@@ -271,6 +395,10 @@ func processImage(ctx *uploadContext, fileHeader *multipart.FileHeader) UploadRe
 		metadata.Paths.AVIF = userPaths.GetAVIFPath(imageID, orientation)
 	}
 
+	if len(thumbnailPaths) > 0 {
+		metadata.Paths.Thumbnails = thumbnailPaths
+	}
+
 	// Set file sizes - always store the actual sizes
 	metadata.Sizes["original"] = originalSize
 	if webpSize > 0 {
@@ -286,6 +414,10 @@ func processImage(ctx *uploadContext, fileHeader *multipart.FileHeader) UploadRe
 		metadata.Sizes["avif"] = originalSize
 	}
 
+	for variant, size := range thumbnailSizes {
+		metadata.Sizes[variant] = size
+	}
+
 	if err := utils.MetadataManager.SaveMetadata(ctx.r.Context(), metadata); err != nil {
 		logger.Warn("Failed to save metadata",
 			zap.String("image_id", imageID),
@@ -297,20 +429,115 @@ func processImage(ctx *uploadContext, fileHeader *multipart.FileHeader) UploadRe
 			zap.String("orientation", orientation))
 	}
 
+	urls := map[string]string{
+		"original": originalURL,
+		"webp":     webpURL,
+		"avif":     avifURL,
+	}
+	for variant, key := range thumbnailPaths {
+		urls[variant] = getPublicURL(key, ctx.cfg)
+	}
+	if private {
+		urls = signedImageURLs(ctx.cfg, imageID, urls)
+	}
+
 	return UploadResult{
-		Filename:    fileHeader.Filename,
+		ID:          imageID,
+		Filename:    originalName,
 		Status:      "success",
 		Message:     "File uploaded and converted successfully",
 		Orientation: orientation,
 		Format:      imgFormat.Format,
 		ExpiryTime:  expiryTimeStr,
 		Tags:        ctx.tags,
-		URLs: map[string]string{
-			"original": originalURL,
-			"webp":     webpURL,
-			"avif":     avifURL,
-		},
+		Visibility:  ctx.visibility,
+		URLs:        urls,
+	}
+}
+
+// duplicateUploadResult reports an existing image instead of storing the same bytes
+// again, merging the requested tags into it when asked to
+func duplicateUploadResult(ctx *uploadContext, filename string, existing *utils.ImageMetadata) UploadResult {
+	if ctx.mergeTags && len(ctx.tags) > 0 {
+		merged := utils.NormalizeTags(append(existing.Tags, ctx.tags...))
+		if len(merged) != len(existing.Tags) {
+			existing.Tags = merged
+			if err := utils.MetadataManager.SaveMetadata(ctx.r.Context(), existing); err != nil {
+				logger.Warn("Failed to merge tags into existing image",
+					zap.String("image_id", existing.ID),
+					zap.Error(err))
+			}
+		}
+	}
+
+	var expiryTimeStr string
+	if !existing.ExpiryTime.IsZero() {
+		expiryTimeStr = existing.ExpiryTime.Format(time.RFC3339)
 	}
+
+	logger.Info("Duplicate upload resolved to existing image",
+		zap.String("filename", filename),
+		zap.String("image_id", existing.ID))
+
+	return UploadResult{
+		ID:          existing.ID,
+		Filename:    filename,
+		Status:      "success",
+		Message:     "Identical image already uploaded",
+		Orientation: existing.Orientation,
+		Format:      existing.Format,
+		URLs:        metadataURLs(existing, ctx.cfg),
+		ExpiryTime:  expiryTimeStr,
+		Tags:        existing.Tags,
+		Visibility:  existing.Visibility,
+		Duplicate:   true,
+	}
+}
+
+// sameUploadSettings reports whether an existing image already has the visibility and
+// expiry requested for an upload. Otherwise the upload is stored as a new image, so a
+// originalStored reports whether the original of an existing image is still in storage,
+// so a duplicate upload never resolves to an image whose files are gone
+func originalStored(ctx *uploadContext, existing *utils.ImageMetadata) bool {
+	if existing.Paths.Original == "" {
+		return false
+	}
+	if _, err := utils.Storage.Stat(ctx.r.Context(), existing.Paths.Original); err != nil {
+		logger.Debug("Original of identical image is missing from storage",
+			zap.String("image_id", existing.ID),
+			zap.Error(err))
+		return false
+	}
+	return true
+}
+
+// private or expiring upload never resolves to a public or permanent one.
+func sameUploadSettings(ctx *uploadContext, existing *utils.ImageMetadata) bool {
+	visibility, err := utils.NormalizeVisibility(existing.Visibility)
+	if err != nil || visibility != ctx.visibility {
+		return false
+	}
+	// Expiry times are relative to the upload, so only uploads without one match
+	return ctx.expiryTime.IsZero() && existing.ExpiryTime.IsZero()
+}
+
+// metadataURLs builds the URLs of all stored renditions of an image, using
+// signed URLs for private images
+func metadataURLs(metadata *utils.ImageMetadata, cfg *config.Config) map[string]string {
+	urls := make(map[string]string, 3+len(metadata.Paths.Thumbnails))
+	for _, variant := range []string{"original", "webp", "avif"} {
+		if key, ok := metadata.VariantPath(variant); ok {
+			urls[variant] = getPublicURL(key, cfg)
+		}
+	}
+	for variant, key := range metadata.Paths.Thumbnails {
+		urls[variant] = getPublicURL(key, cfg)
+	}
+
+	if metadata.IsPrivate() {
+		return signedImageURLs(cfg, metadata.ID, urls)
+	}
+	return urls
 }
 
 type uploadContext struct {
@@ -318,6 +545,8 @@ type uploadContext struct {
 	user       *utils.User
 	expiryTime time.Time
 	tags       []string
+	visibility string
+	mergeTags  bool
 	cfg        *config.Config
 }
 
@@ -392,11 +621,20 @@ func UploadHandler(cfg *config.Config) http.HandlerFunc {
 			logger.Debug("图片标签", zap.Strings("tags", tags))
 		}
 
+		// Get visibility parameter (public, unlisted or private)
+		visibility, err := utils.NormalizeVisibility(r.FormValue("visibility"))
+		if err != nil {
+			errors.HandleError(w, errors.ErrInvalidParam, "无效的可见性参数", nil)
+			return
+		}
+
 		ctx := &uploadContext{
 			r:          r,
 			user:       user,
 			expiryTime: expiryTime,
 			tags:       tags,
+			visibility: visibility,
+			mergeTags:  r.FormValue("mergeTags") == "true",
 			cfg:        cfg,
 		}
 
@@ -408,7 +646,7 @@ func UploadHandler(cfg *config.Config) http.HandlerFunc {
 			wg.Add(1)
 			go func(fh *multipart.FileHeader) {
 				defer wg.Done()
-				result := processImage(ctx, fh)
+				result := processUploadedFile(ctx, fh)
 				resultsChan <- result
 			}(fileHeader)
 		}
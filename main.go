@@ -65,7 +65,7 @@ func corsMiddleware(next http.Handler) http.Handler {
 		}
 
 		// Set other CORS headers
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With")
 		w.Header().Set("Access-Control-Max-Age", "86400") // 24 hours
 
@@ -125,6 +125,11 @@ func main() {
 		logger.Fatal("Failed to initialize metadata store", zap.Error(err))
 	}
 
+	// Initialize derived variant cache
+	if err := utils.InitVariantCache(cfg); err != nil {
+		logger.Warn("Failed to initialize variant cache", zap.Error(err))
+	}
+
 	// Initialize OIDC provider
 	if err := utils.InitOIDCProvider(cfg); err != nil {
 		logger.Fatal("Failed to initialize OIDC provider", zap.Error(err))
@@ -155,6 +160,9 @@ func main() {
 		http.HandleFunc("/api/auth/callback", handlers.OIDCCallbackAPIHandler(cfg)) // New API endpoint
 		http.HandleFunc("/api/auth/logout", handlers.LogoutHandler(cfg))
 		http.HandleFunc("/api/auth/profile", handlers.RequireAuth(cfg, handlers.UserProfileHandler(cfg)))
+
+		// Per-user random image feeds, addressed by the handle set on the profile
+		http.HandleFunc("/api/random/u/{handle}", handlers.UserRandomImageHandler(cfg))
 	} else {
 		// Legacy API Key validation
 		http.HandleFunc("/api/validate-api-key", handlers.ValidateAPIKey(cfg))
@@ -162,7 +170,19 @@ func main() {
 
 	// Protected API routes (work with both auth types)
 	http.HandleFunc("/api/upload", handlers.RequireAuth(cfg, handlers.UploadHandler(cfg)))
+	http.HandleFunc("/api/upload/url", handlers.RequireAuth(cfg, handlers.RemoteUploadHandler(cfg)))
+	http.HandleFunc("/api/uploads", handlers.RequireAuth(cfg, handlers.CreateChunkedUploadHandler(cfg)))
+	http.HandleFunc("/api/uploads/{uploadId}", handlers.RequireAuth(cfg, handlers.ChunkedUploadHandler(cfg)))
+	http.HandleFunc("/api/uploads/{uploadId}/chunks/{index}", handlers.RequireAuth(cfg, handlers.UploadChunkHandler(cfg)))
+	http.HandleFunc("/api/uploads/{uploadId}/complete", handlers.RequireAuth(cfg, handlers.CompleteChunkedUploadHandler(cfg)))
 	http.HandleFunc("/api/images", handlers.RequireAuth(cfg, handlers.ListImagesHandler(cfg)))
+	http.HandleFunc("/api/images/batch", handlers.RequireAuth(cfg, handlers.BatchHandler(cfg)))
+	http.HandleFunc("/api/images/{id}", handlers.RequireAuth(cfg, handlers.UpdateImageHandler(cfg)))
+	http.HandleFunc("/api/images/{id}/file", handlers.RequireAuth(cfg, handlers.ImageFileHandler(cfg)))
+	http.HandleFunc("/api/collections", handlers.RequireAuth(cfg, handlers.CollectionsHandler(cfg)))
+	http.HandleFunc("/api/collections/{id}", handlers.RequireAuth(cfg, handlers.CollectionHandler(cfg)))
+	http.HandleFunc("/api/collections/{id}/images", handlers.RequireAuth(cfg, handlers.CollectionImagesHandler(cfg)))
+	http.HandleFunc("/api/duplicates", handlers.RequireAuth(cfg, handlers.DuplicatesHandler(cfg)))
 	http.HandleFunc("/api/delete-image", handlers.RequireAuth(cfg, handlers.DeleteImageHandler(cfg)))
 	http.HandleFunc("/api/config", handlers.RequireAuth(cfg, handlers.ConfigHandler(cfg)))
 	http.HandleFunc("/api/tags", handlers.RequireAuth(cfg, handlers.TagsHandler(cfg)))
@@ -185,16 +205,23 @@ func main() {
 		})
 	}))
 
+	// Signed, expiring image URLs
+	http.HandleFunc("/api/sign-url", handlers.RequireAuth(cfg, handlers.SignURLHandler(cfg)))
+	http.HandleFunc(utils.SignedURLPath+"{id}", handlers.SignedImageHandler(cfg))
+
+	// On-the-fly resize/crop transforms of stored originals
+	http.HandleFunc("/api/transform/{id}", handlers.TransformHandler(cfg))
+
 	// Use appropriate random image handler based on storage type
 	if cfg.StorageType == config.StorageTypeS3 {
-		http.HandleFunc("/api/random", handlers.RandomImageHandler(utils.S3Client, cfg))
+		http.HandleFunc("/api/random", handlers.RandomImageHandler(cfg))
 	} else {
 		http.HandleFunc("/api/random", handlers.LocalRandomImageHandler(cfg))
 		// Serve local images
 		if !filepath.IsAbs(cfg.ImageBasePath) {
 			cfg.ImageBasePath = filepath.Join(".", cfg.ImageBasePath)
 		}
-		http.Handle("/images/", http.StripPrefix("/images/", http.FileServer(http.Dir(cfg.ImageBasePath))))
+		http.Handle("/images/", http.StripPrefix("/images/", handlers.GuardPrivateImages(http.FileServer(http.Dir(cfg.ImageBasePath)))))
 	}
 
 	// Serve static files
@@ -315,10 +342,16 @@ func ensureDirectories(cfg *config.Config) {
 	dirs := []string{
 		filepath.Join(cfg.ImageBasePath, "original", "landscape"),
 		filepath.Join(cfg.ImageBasePath, "original", "portrait"),
+		filepath.Join(cfg.ImageBasePath, "original", "square"),
 		filepath.Join(cfg.ImageBasePath, "landscape", "webp"),
 		filepath.Join(cfg.ImageBasePath, "landscape", "avif"),
 		filepath.Join(cfg.ImageBasePath, "portrait", "webp"),
 		filepath.Join(cfg.ImageBasePath, "portrait", "avif"),
+		filepath.Join(cfg.ImageBasePath, "square", "webp"),
+		filepath.Join(cfg.ImageBasePath, "square", "avif"),
+		filepath.Join(cfg.ImageBasePath, "landscape", "thumb"),
+		filepath.Join(cfg.ImageBasePath, "portrait", "thumb"),
+		filepath.Join(cfg.ImageBasePath, "square", "thumb"),
 		filepath.Join(cfg.ImageBasePath, "gif"),
 	}
 
@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// AspectMatchTolerance is the relative difference from a requested aspect ratio that
+// still matches it, so that e.g. 1366x768 counts as 16:9
+const AspectMatchTolerance = 0.01
+
+// ClassifyOrientation classifies an image as landscape, portrait or square. Images
+// whose sides differ by at most tolerance times the longer side are square.
+func ClassifyOrientation(width, height int, tolerance float64) string {
+	if width > 0 && height > 0 && math.Abs(float64(width-height)) <= tolerance*float64(max(width, height)) {
+		return "square"
+	}
+	if width > height {
+		return "landscape"
+	}
+	return "portrait"
+}
+
+// AspectRatio returns width divided by height rounded to four decimals, or 0 when a
+// dimension is unknown
+func AspectRatio(width, height int) float64 {
+	if width <= 0 || height <= 0 {
+		return 0
+	}
+	return math.Round(float64(width)/float64(height)*10000) / 10000
+}
+
+// ParseAspectRatio parses a ratio written as width:height (16:9), width/height or a
+// decimal number (1.78)
+func ParseAspectRatio(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	sep := strings.IndexAny(s, ":/")
+	if sep < 0 {
+		ratio, err := strconv.ParseFloat(s, 64)
+		if err != nil || ratio <= 0 || math.IsInf(ratio, 0) {
+			return 0, fmt.Errorf("invalid aspect ratio: %s", s)
+		}
+		return ratio, nil
+	}
+
+	width, errW := strconv.ParseFloat(strings.TrimSpace(s[:sep]), 64)
+	height, errH := strconv.ParseFloat(strings.TrimSpace(s[sep+1:]), 64)
+	if errW != nil || errH != nil || width <= 0 || height <= 0 || math.IsInf(width/height, 0) {
+		return 0, fmt.Errorf("invalid aspect ratio: %s", s)
+	}
+	return width / height, nil
+}
+
+// RatioRange bounds the aspect ratio of images. A zero bound is open.
+type RatioRange struct {
+	Min float64 // Smallest width/height ratio accepted
+	Max float64 // Largest width/height ratio accepted
+}
+
+// ParseRatioRange builds a range from the aspect, min_ratio and max_ratio query
+// parameters. An exact aspect matches within AspectMatchTolerance and narrows the
+// explicit bounds; values that do not parse are ignored.
+func ParseRatioRange(aspect, minRatio, maxRatio string) RatioRange {
+	var r RatioRange
+	if minRatio != "" {
+		if ratio, err := ParseAspectRatio(minRatio); err == nil {
+			r.Min = ratio
+		}
+	}
+	if maxRatio != "" {
+		if ratio, err := ParseAspectRatio(maxRatio); err == nil {
+			r.Max = ratio
+		}
+	}
+	if aspect != "" {
+		if ratio, err := ParseAspectRatio(aspect); err == nil {
+			r.Min = max(r.Min, ratio*(1-AspectMatchTolerance))
+			if r.Max == 0 {
+				r.Max = ratio * (1 + AspectMatchTolerance)
+			} else {
+				r.Max = min(r.Max, ratio*(1+AspectMatchTolerance))
+			}
+		}
+	}
+	return r
+}
+
+// IsSet reports whether the range restricts the aspect ratio at all
+func (r RatioRange) IsSet() bool {
+	return r.Min > 0 || r.Max > 0
+}
+
+// Contains reports whether an aspect ratio lies in the range. Images of unknown
+// ratio never match a range that is set.
+func (r RatioRange) Contains(ratio float64) bool {
+	if !r.IsSet() {
+		return true
+	}
+	return ratio > 0 && ratio >= r.Min && (r.Max == 0 || ratio <= r.Max)
+}
+
+// String formats the range for cache keys and logs
+func (r RatioRange) String() string {
+	if !r.IsSet() {
+		return ""
+	}
+	return strconv.FormatFloat(r.Min, 'f', -1, 64) + "-" + strconv.FormatFloat(r.Max, 'f', -1, 64)
+}
+
+// scoreBounds returns the range as ZRANGEBYSCORE bounds
+func (r RatioRange) scoreBounds() (string, string) {
+	lower, upper := "-inf", "+inf"
+	if r.Min > 0 {
+		lower = strconv.FormatFloat(r.Min, 'f', -1, 64)
+	}
+	if r.Max > 0 {
+		upper = strconv.FormatFloat(r.Max, 'f', -1, 64)
+	}
+	return lower, upper
+}
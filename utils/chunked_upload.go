@@ -0,0 +1,306 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Yuri-NagaSaki/ImageFlow/utils/logger"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Chunked upload limits
+const (
+	UploadStagingDir   = "uploads"        // Storage directory holding staged chunks
+	UploadSessionTTL   = 24 * time.Hour   // Time a client has to finish an upload
+	DefaultChunkSize   = 5 << 20          // Chunk size used when the client does not ask for one
+	MinChunkSize       = 256 << 10        // Smallest chunk size accepted
+	MaxChunkSize       = 32 << 20         // Largest chunk size accepted, same as multipart uploads
+	uploadSessionGrace = 30 * time.Minute // Redis keeps expired sessions this long so the cleaner can find their chunks
+)
+
+// UploadSession tracks a resumable upload whose chunks are staged in storage
+type UploadSession struct {
+	ID            string    `json:"uploadId"`      // Random upload ID
+	UserID        string    `json:"-"`             // Owner of the upload
+	Filename      string    `json:"filename"`      // Original filename
+	Size          int64     `json:"size"`          // Total size in bytes
+	ChunkSize     int64     `json:"chunkSize"`     // Size of every chunk except the last one
+	TotalChunks   int       `json:"totalChunks"`   // Number of chunks
+	Tags          []string  `json:"tags"`          // Tags applied on completion
+	ExpiryMinutes int       `json:"expiryMinutes"` // Image expiry applied on completion (0 = never)
+	Visibility    string    `json:"visibility"`    // Visibility applied on completion
+	MergeTags     bool      `json:"mergeTags"`     // Merge tags into an identical existing image
+	ExpiresAt     time.Time `json:"expiresAt"`     // Time after which unfinished chunks are discarded
+}
+
+// uploadSessionKey returns the Redis hash holding an upload session
+func uploadSessionKey(id string) string {
+	return RedisPrefix + "upload:" + id
+}
+
+// uploadChunksKey returns the Redis set of chunk indexes received for an upload
+func uploadChunksKey(id string) string {
+	return RedisPrefix + "upload:" + id + ":chunks"
+}
+
+// uploadSessionsKey is the sorted set of upload IDs scored by session expiry
+func uploadSessionsKey() string {
+	return RedisPrefix + "uploads"
+}
+
+// ChunkKey returns the storage key of a staged chunk
+func ChunkKey(uploadID string, index int) string {
+	return fmt.Sprintf("%s/%s/%d", UploadStagingDir, uploadID, index)
+}
+
+// ChunkLength returns the expected size of a chunk; only the last chunk may be shorter
+func (s *UploadSession) ChunkLength(index int) int64 {
+	if index == s.TotalChunks-1 {
+		return s.Size - int64(index)*s.ChunkSize
+	}
+	return s.ChunkSize
+}
+
+// CreateUploadSession assigns an ID to a new upload session and records it in Redis
+func CreateUploadSession(ctx context.Context, s *UploadSession) error {
+	if !IsRedisMetadataStore() {
+		return fmt.Errorf("redis not enabled")
+	}
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return fmt.Errorf("failed to generate upload ID: %v", err)
+	}
+	s.ID = hex.EncodeToString(idBytes)
+	s.TotalChunks = int((s.Size + s.ChunkSize - 1) / s.ChunkSize)
+	s.ExpiresAt = time.Now().Add(UploadSessionTTL)
+
+	key := uploadSessionKey(s.ID)
+	pipe := RedisClient.TxPipeline()
+	pipe.HSet(ctx, key, map[string]interface{}{
+		"userID":        s.UserID,
+		"filename":      s.Filename,
+		"size":          s.Size,
+		"chunkSize":     s.ChunkSize,
+		"totalChunks":   s.TotalChunks,
+		"tags":          strings.Join(s.Tags, ","),
+		"expiryMinutes": s.ExpiryMinutes,
+		"visibility":    s.Visibility,
+		"mergeTags":     strconv.FormatBool(s.MergeTags),
+		"expiresAt":     s.ExpiresAt.Format(time.RFC3339),
+	})
+	pipe.ExpireAt(ctx, key, s.ExpiresAt.Add(uploadSessionGrace))
+	pipe.ZAdd(ctx, uploadSessionsKey(), redis.Z{
+		Score:  float64(s.ExpiresAt.Unix()),
+		Member: s.ID,
+	})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to save upload session: %v", err)
+	}
+
+	logger.Debug("Upload session created",
+		zap.String("upload_id", s.ID),
+		zap.String("user_id", s.UserID),
+		zap.Int64("size", s.Size),
+		zap.Int("chunks", s.TotalChunks))
+	return nil
+}
+
+// GetUploadSession loads an upload session, returning an error if it does not exist or has expired
+func GetUploadSession(ctx context.Context, id string) (*UploadSession, error) {
+	if !IsRedisMetadataStore() {
+		return nil, fmt.Errorf("redis not enabled")
+	}
+
+	data, err := RedisClient.HGetAll(ctx, uploadSessionKey(id)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload session: %v", err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("upload session not found: %s", id)
+	}
+
+	s := &UploadSession{
+		ID:         id,
+		UserID:     data["userID"],
+		Filename:   data["filename"],
+		Visibility: data["visibility"],
+		MergeTags:  data["mergeTags"] == "true",
+	}
+	s.Size, _ = strconv.ParseInt(data["size"], 10, 64)
+	s.ChunkSize, _ = strconv.ParseInt(data["chunkSize"], 10, 64)
+	s.TotalChunks, _ = strconv.Atoi(data["totalChunks"])
+	s.ExpiryMinutes, _ = strconv.Atoi(data["expiryMinutes"])
+	s.ExpiresAt, _ = time.Parse(time.RFC3339, data["expiresAt"])
+	if tags := data["tags"]; tags != "" {
+		s.Tags = strings.Split(tags, ",")
+	}
+
+	if s.ChunkSize <= 0 || s.TotalChunks <= 0 || time.Now().After(s.ExpiresAt) {
+		return nil, fmt.Errorf("upload session expired: %s", id)
+	}
+	return s, nil
+}
+
+// MarkChunkReceived records that a chunk has been staged
+func MarkChunkReceived(ctx context.Context, s *UploadSession, index int) error {
+	key := uploadChunksKey(s.ID)
+	pipe := RedisClient.TxPipeline()
+	pipe.SAdd(ctx, key, index)
+	pipe.ExpireAt(ctx, key, s.ExpiresAt.Add(uploadSessionGrace))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to record chunk: %v", err)
+	}
+	return nil
+}
+
+// ReceivedChunks returns the sorted indexes of the chunks staged so far
+func ReceivedChunks(ctx context.Context, id string) ([]int, error) {
+	members, err := RedisClient.SMembers(ctx, uploadChunksKey(id)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read received chunks: %v", err)
+	}
+
+	indexes := make([]int, 0, len(members))
+	for _, member := range members {
+		if index, err := strconv.Atoi(member); err == nil {
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Ints(indexes)
+	return indexes, nil
+}
+
+// MissingChunks returns the indexes of the chunks not yet received
+func (s *UploadSession) MissingChunks(received []int) []int {
+	have := make(map[int]bool, len(received))
+	for _, index := range received {
+		have[index] = true
+	}
+
+	missing := make([]int, 0)
+	for index := 0; index < s.TotalChunks; index++ {
+		if !have[index] {
+			missing = append(missing, index)
+		}
+	}
+	return missing
+}
+
+// ClaimUploadCompletion marks an upload as being completed, returning false if
+// another request is already completing it
+func ClaimUploadCompletion(ctx context.Context, id string) (bool, error) {
+	return RedisClient.HSetNX(ctx, uploadSessionKey(id), "completing", "1").Result()
+}
+
+// ReleaseUploadCompletion allows a failed completion to be retried
+func ReleaseUploadCompletion(ctx context.Context, id string) {
+	if err := RedisClient.HDel(ctx, uploadSessionKey(id), "completing").Err(); err != nil {
+		logger.Warn("Failed to release upload completion",
+			zap.String("upload_id", id),
+			zap.Error(err))
+	}
+}
+
+// AssembleUpload concatenates the staged chunks of an upload. The image pipeline
+// works on the whole file in memory, so the chunks are streamed into a single
+// buffer of the final size rather than loaded one by one and copied.
+func AssembleUpload(ctx context.Context, s *UploadSession) ([]byte, error) {
+	data := make([]byte, s.Size)
+	var offset int64
+	for index := 0; index < s.TotalChunks; index++ {
+		length := s.ChunkLength(index)
+		if offset+length > s.Size {
+			return nil, fmt.Errorf("chunk %d exceeds the upload size", index)
+		}
+		if err := readChunk(ctx, ChunkKey(s.ID, index), data[offset:offset+length]); err != nil {
+			return nil, fmt.Errorf("failed to read chunk %d: %v", index, err)
+		}
+		offset += length
+	}
+	return data, nil
+}
+
+// readChunk reads a staged chunk into buf, which must have exactly its size
+func readChunk(ctx context.Context, key string, buf []byte) error {
+	reader, info, err := Storage.Open(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if info.Size >= 0 && info.Size != int64(len(buf)) {
+		return fmt.Errorf("chunk has %d bytes, expected %d", info.Size, len(buf))
+	}
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteUploadSession removes the staged chunks and Redis state of an upload
+func DeleteUploadSession(ctx context.Context, id string, totalChunks int) {
+	for index := 0; index < totalChunks; index++ {
+		if err := Storage.Delete(ctx, ChunkKey(id, index)); err != nil {
+			logger.Debug("Failed to delete staged chunk",
+				zap.String("upload_id", id),
+				zap.Int("index", index),
+				zap.Error(err))
+		}
+	}
+
+	if localStorage, ok := Storage.(*LocalStorage); ok {
+		os.Remove(filepath.Join(localStorage.BasePath, UploadStagingDir, id))
+	}
+
+	pipe := RedisClient.TxPipeline()
+	pipe.Del(ctx, uploadSessionKey(id), uploadChunksKey(id))
+	pipe.ZRem(ctx, uploadSessionsKey(), id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.Warn("Failed to delete upload session",
+			zap.String("upload_id", id),
+			zap.Error(err))
+	}
+}
+
+// CleanupAbandonedUploads discards the staged chunks of uploads that were never completed
+func CleanupAbandonedUploads(ctx context.Context) {
+	if !IsRedisMetadataStore() {
+		return
+	}
+
+	ids, err := RedisClient.ZRangeByScore(ctx, uploadSessionsKey(), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(time.Now().Unix(), 10),
+	}).Result()
+	if err != nil {
+		logger.Error("Failed to list abandoned uploads", zap.Error(err))
+		return
+	}
+
+	for _, id := range ids {
+		// The session hash outlives the expiry by a grace period; without it the
+		// chunk count is unknown and the received set is the best guide
+		totalChunks, _ := RedisClient.HGet(ctx, uploadSessionKey(id), "totalChunks").Int()
+		if totalChunks == 0 {
+			if received, err := ReceivedChunks(ctx, id); err == nil && len(received) > 0 {
+				totalChunks = received[len(received)-1] + 1
+			}
+		}
+		DeleteUploadSession(ctx, id, totalChunks)
+	}
+
+	if len(ids) > 0 {
+		logger.Info("Cleaned up abandoned uploads", zap.Int("count", len(ids)))
+	}
+}
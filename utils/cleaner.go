@@ -56,9 +56,11 @@ func (ic *ImageCleaner) Stop() {
 	logger.Info("Image cleaner stopped")
 }
 
-// cleanExpiredImages removes all expired images
+// cleanExpiredImages removes all expired images and abandoned resumable uploads
 func (ic *ImageCleaner) cleanExpiredImages() {
 	ctx := context.Background()
+	CleanupAbandonedUploads(ctx)
+
 	expiredImages, err := MetadataManager.ListExpiredImages(ctx)
 	if err != nil {
 		logger.Error("Failed to list expired images", zap.Error(err))
@@ -114,6 +116,12 @@ func (ic *ImageCleaner) cleanExpiredImages() {
 			}
 		}
 
+		// Delete thumbnails
+		DeleteThumbnails(ctx, metadata)
+
+		// Delete cached transform variants
+		Variants.Purge(ctx, metadata)
+
 		// Delete metadata
 		if err := MetadataManager.DeleteMetadata(ctx, metadata.ID); err != nil {
 			logger.Error("Failed to delete metadata",
@@ -125,6 +133,15 @@ func (ic *ImageCleaner) cleanExpiredImages() {
 		}
 	}
 
+	expiredIDs := make([]string, len(expiredImages))
+	for i, metadata := range expiredImages {
+		expiredIDs[i] = metadata.ID
+	}
+	if err := RemoveFromCollections(ctx, expiredIDs); err != nil {
+		logger.Warn("Failed to remove expired images from collections",
+			zap.Error(err))
+	}
+
 	// Clear page cache after deleting all expired images
 	if err := ClearPageCache(ctx); err != nil {
 		logger.Warn("Failed to clear page cache",
@@ -0,0 +1,568 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Yuri-NagaSaki/ImageFlow/utils/logger"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Collection limits
+const (
+	MaxCollectionNameLength = 100   // Longest collection name accepted
+	MaxCollectionImages     = 10000 // Most images a single collection may hold
+)
+
+// Collection is a named, ordered set of images owned by a user
+type Collection struct {
+	ID        string    `json:"id"`        // Random collection ID
+	UserID    string    `json:"user_id"`   // User ID who owns this collection
+	Name      string    `json:"name"`      // Display name
+	ImageIDs  []string  `json:"imageIds"`  // Image IDs in display order
+	CreatedAt time.Time `json:"createdAt"` // Creation timestamp
+	UpdatedAt time.Time `json:"updatedAt"` // Last modification timestamp
+}
+
+// NewCollectionID generates a random collection ID
+func NewCollectionID() (string, error) {
+	idBytes := make([]byte, 12)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", fmt.Errorf("failed to generate collection ID: %v", err)
+	}
+	return hex.EncodeToString(idBytes), nil
+}
+
+// IsValidCollectionID reports whether an ID has the format generated by NewCollectionID.
+// IDs name files and objects of the metadata stores, so nothing else may reach them.
+func IsValidCollectionID(id string) bool {
+	if len(id) != 24 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// Contains reports whether the collection holds an image
+func (c *Collection) Contains(imageID string) bool {
+	for _, id := range c.ImageIDs {
+		if id == imageID {
+			return true
+		}
+	}
+	return false
+}
+
+// MemberSet returns the image IDs of the collection as a set
+func (c *Collection) MemberSet() map[string]bool {
+	members := make(map[string]bool, len(c.ImageIDs))
+	for _, id := range c.ImageIDs {
+		members[id] = true
+	}
+	return members
+}
+
+// collectionKey returns the Redis hash holding a collection
+func collectionKey(id string) string {
+	return RedisPrefix + "collection:" + id
+}
+
+// userCollectionsKey is the sorted set of a user's collection IDs scored by creation time
+func userCollectionsKey(userID string) string {
+	return RedisPrefix + "user:" + userID + ":collections"
+}
+
+// allCollectionsKey is the sorted set of all collection IDs scored by creation time
+func allCollectionsKey() string {
+	return RedisPrefix + "collections"
+}
+
+// imageCollectionsKey is the set of IDs of the collections holding an image
+func imageCollectionsKey(imageID string) string {
+	return RedisPrefix + "image:" + imageID + ":collections"
+}
+
+// CollectionIndexDir holds the image to collection index of the file-based stores,
+// one empty entry per image and collection at <dir>/<image ID>/<collection ID>
+const CollectionIndexDir = "collections_by_image"
+
+// memberChanges returns the images a new member list adds to and removes from an old one
+func memberChanges(old, new []string) (added, removed []string) {
+	before := make(map[string]bool, len(old))
+	for _, id := range old {
+		before[id] = true
+	}
+	after := make(map[string]bool, len(new))
+	for _, id := range new {
+		after[id] = true
+		if !before[id] {
+			added = append(added, id)
+		}
+	}
+	for _, id := range old {
+		if !after[id] {
+			removed = append(removed, id)
+		}
+	}
+	return added, removed
+}
+
+// SaveCollection saves a collection to Redis
+func (rms *RedisMetadataStore) SaveCollection(ctx context.Context, collection *Collection) error {
+	if !IsRedisMetadataStore() {
+		return fmt.Errorf("redis not enabled")
+	}
+
+	imagesJSON, err := json.Marshal(collection.ImageIDs)
+	if err != nil {
+		return fmt.Errorf("failed to marshal collection images: %v", err)
+	}
+
+	var previous []string
+	if images, err := RedisClient.HGet(ctx, collectionKey(collection.ID), "images").Result(); err == nil {
+		json.Unmarshal([]byte(images), &previous)
+	} else if err != redis.Nil {
+		return fmt.Errorf("failed to get collection from Redis: %v", err)
+	}
+	added, removed := memberChanges(previous, collection.ImageIDs)
+
+	created := redis.Z{
+		Score:  float64(collection.CreatedAt.Unix()),
+		Member: collection.ID,
+	}
+
+	pipe := RedisClient.TxPipeline()
+	for _, id := range added {
+		pipe.SAdd(ctx, imageCollectionsKey(id), collection.ID)
+	}
+	for _, id := range removed {
+		pipe.SRem(ctx, imageCollectionsKey(id), collection.ID)
+	}
+	pipe.HSet(ctx, collectionKey(collection.ID), map[string]interface{}{
+		"id":        collection.ID,
+		"userID":    collection.UserID,
+		"name":      collection.Name,
+		"images":    string(imagesJSON),
+		"createdAt": collection.CreatedAt.Format(time.RFC3339),
+		"updatedAt": collection.UpdatedAt.Format(time.RFC3339),
+	})
+	pipe.ZAdd(ctx, userCollectionsKey(collection.UserID), created)
+	pipe.ZAdd(ctx, allCollectionsKey(), created)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to save collection to Redis: %v", err)
+	}
+
+	// Image listings may be filtered by collection
+	if err := ClearPageCache(ctx); err != nil {
+		logger.Warn("Failed to clear page cache", zap.Error(err))
+	}
+
+	logger.Debug("Collection saved to Redis",
+		zap.String("collection_id", collection.ID),
+		zap.Int("images", len(collection.ImageIDs)))
+	return nil
+}
+
+// GetCollection retrieves a collection from Redis
+func (rms *RedisMetadataStore) GetCollection(ctx context.Context, id string) (*Collection, error) {
+	if !IsRedisMetadataStore() {
+		return nil, fmt.Errorf("redis not enabled")
+	}
+
+	data, err := RedisClient.HGetAll(ctx, collectionKey(id)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get collection from Redis: %v", err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("collection not found: %s", id)
+	}
+	return collectionFromHash(data), nil
+}
+
+// collectionFromHash decodes a collection stored as a Redis hash
+func collectionFromHash(data map[string]string) *Collection {
+	collection := &Collection{
+		ID:     data["id"],
+		UserID: data["userID"],
+		Name:   data["name"],
+	}
+	collection.CreatedAt, _ = time.Parse(time.RFC3339, data["createdAt"])
+	collection.UpdatedAt, _ = time.Parse(time.RFC3339, data["updatedAt"])
+	if images := data["images"]; images != "" {
+		json.Unmarshal([]byte(images), &collection.ImageIDs)
+	}
+	return collection
+}
+
+// ListCollections lists a user's collections from Redis, oldest first; an empty
+// user ID lists every collection
+func (rms *RedisMetadataStore) ListCollections(ctx context.Context, userID string) ([]*Collection, error) {
+	if !IsRedisMetadataStore() {
+		return nil, fmt.Errorf("redis not enabled")
+	}
+
+	indexKey := allCollectionsKey()
+	if userID != "" {
+		indexKey = userCollectionsKey(userID)
+	}
+	ids, err := RedisClient.ZRange(ctx, indexKey, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list collections: %v", err)
+	}
+
+	pipe := RedisClient.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.HGetAll(ctx, collectionKey(id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to get collections: %v", err)
+	}
+
+	collections := make([]*Collection, 0, len(ids))
+	for _, cmd := range cmds {
+		if data, err := cmd.Result(); err == nil && len(data) > 0 {
+			collections = append(collections, collectionFromHash(data))
+		}
+	}
+	return collections, nil
+}
+
+// DeleteCollection deletes a collection from Redis
+func (rms *RedisMetadataStore) DeleteCollection(ctx context.Context, id string) error {
+	collection, err := rms.GetCollection(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	pipe := RedisClient.TxPipeline()
+	for _, imageID := range collection.ImageIDs {
+		pipe.SRem(ctx, imageCollectionsKey(imageID), id)
+	}
+	pipe.Del(ctx, collectionKey(id))
+	pipe.ZRem(ctx, userCollectionsKey(collection.UserID), id)
+	pipe.ZRem(ctx, allCollectionsKey(), id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to delete collection from Redis: %v", err)
+	}
+
+	if err := ClearPageCache(ctx); err != nil {
+		logger.Warn("Failed to clear page cache", zap.Error(err))
+	}
+
+	logger.Info("Collection deleted from Redis",
+		zap.String("collection_id", id))
+	return nil
+}
+
+// ImageCollections lists the IDs of the collections holding an image from Redis
+func (rms *RedisMetadataStore) ImageCollections(ctx context.Context, imageID string) ([]string, error) {
+	if !IsRedisMetadataStore() {
+		return nil, fmt.Errorf("redis not enabled")
+	}
+
+	ids, err := RedisClient.SMembers(ctx, imageCollectionsKey(imageID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image collections from Redis: %v", err)
+	}
+	return ids, nil
+}
+
+// SaveCollection saves a collection to a local file
+func (lms *LocalMetadataStore) SaveCollection(ctx context.Context, collection *Collection) error {
+	collectionsDir := filepath.Join(lms.BasePath, "collections")
+	if err := os.MkdirAll(collectionsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create collections directory: %v", err)
+	}
+
+	data, err := json.Marshal(collection)
+	if err != nil {
+		return fmt.Errorf("failed to marshal collection: %v", err)
+	}
+
+	var previous []string
+	if existing, err := lms.GetCollection(ctx, collection.ID); err == nil {
+		previous = existing.ImageIDs
+	}
+
+	if err := os.WriteFile(filepath.Join(collectionsDir, collection.ID+".json"), data, 0644); err != nil {
+		return fmt.Errorf("failed to write collection file: %v", err)
+	}
+
+	added, removed := memberChanges(previous, collection.ImageIDs)
+	for _, id := range added {
+		dir := filepath.Join(lms.BasePath, CollectionIndexDir, filepath.Base(id))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create collection index directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, collection.ID), nil, 0644); err != nil {
+			return fmt.Errorf("failed to write collection index entry: %v", err)
+		}
+	}
+	lms.removeCollectionIndex(collection.ID, removed)
+	return nil
+}
+
+// removeCollectionIndex deletes the index entries of images leaving a collection
+func (lms *LocalMetadataStore) removeCollectionIndex(collectionID string, imageIDs []string) {
+	for _, id := range imageIDs {
+		dir := filepath.Join(lms.BasePath, CollectionIndexDir, filepath.Base(id))
+		os.Remove(filepath.Join(dir, collectionID))
+		// Only succeeds once no collection holds the image anymore
+		os.Remove(dir)
+	}
+}
+
+// GetCollection retrieves a collection from a local file
+func (lms *LocalMetadataStore) GetCollection(ctx context.Context, id string) (*Collection, error) {
+	if !IsValidCollectionID(id) {
+		return nil, fmt.Errorf("invalid collection ID: %s", id)
+	}
+	data, err := os.ReadFile(filepath.Join(lms.BasePath, "collections", id+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read collection file: %v", err)
+	}
+
+	var collection Collection
+	if err := json.Unmarshal(data, &collection); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal collection: %v", err)
+	}
+	return &collection, nil
+}
+
+// ListCollections lists a user's collections from local files, oldest first; an
+// empty user ID lists every collection
+func (lms *LocalMetadataStore) ListCollections(ctx context.Context, userID string) ([]*Collection, error) {
+	files, err := os.ReadDir(filepath.Join(lms.BasePath, "collections"))
+	if os.IsNotExist(err) {
+		return []*Collection{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read collections directory: %v", err)
+	}
+
+	var ids []string
+	for _, file := range files {
+		if !file.IsDir() && filepath.Ext(file.Name()) == ".json" {
+			ids = append(ids, strings.TrimSuffix(file.Name(), ".json"))
+		}
+	}
+	return listCollections(ctx, ids, userID, lms.GetCollection), nil
+}
+
+// DeleteCollection deletes a collection file
+func (lms *LocalMetadataStore) DeleteCollection(ctx context.Context, id string) error {
+	if !IsValidCollectionID(id) {
+		return fmt.Errorf("invalid collection ID: %s", id)
+	}
+	collection, err := lms.GetCollection(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(lms.BasePath, "collections", id+".json")); err != nil {
+		return err
+	}
+	lms.removeCollectionIndex(id, collection.ImageIDs)
+	return nil
+}
+
+// ImageCollections lists the IDs of the collections holding an image from the local index
+func (lms *LocalMetadataStore) ImageCollections(ctx context.Context, imageID string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(lms.BasePath, CollectionIndexDir, filepath.Base(imageID)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read collection index: %v", err)
+	}
+
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.Name())
+	}
+	return ids, nil
+}
+
+// collectionsPrefix is the S3 prefix holding collection documents
+const collectionsPrefix = "collections/"
+
+// SaveCollection saves a collection to S3
+func (sms *S3MetadataStore) SaveCollection(ctx context.Context, collection *Collection) error {
+	data, err := json.Marshal(collection)
+	if err != nil {
+		return fmt.Errorf("failed to marshal collection: %v", err)
+	}
+
+	// Collections list the private images of their owner, so they are never public
+	var previous []string
+	if existing, err := sms.GetCollection(ctx, collection.ID); err == nil {
+		previous = existing.ImageIDs
+	}
+
+	if err := sms.client.StoreWithACL(ctx, collectionsPrefix+collection.ID+".json", data, true); err != nil {
+		return fmt.Errorf("failed to store collection in S3: %v", err)
+	}
+
+	added, removed := memberChanges(previous, collection.ImageIDs)
+	for _, id := range added {
+		if err := sms.client.StoreWithACL(ctx, path.Join(CollectionIndexDir, id, collection.ID), nil, true); err != nil {
+			return fmt.Errorf("failed to store collection index entry in S3: %v", err)
+		}
+	}
+	sms.removeCollectionIndex(ctx, collection.ID, removed)
+	return nil
+}
+
+// removeCollectionIndex deletes the index entries of images leaving a collection
+func (sms *S3MetadataStore) removeCollectionIndex(ctx context.Context, collectionID string, imageIDs []string) {
+	for _, id := range imageIDs {
+		if err := sms.client.Delete(ctx, path.Join(CollectionIndexDir, id, collectionID)); err != nil {
+			logger.Warn("Failed to delete collection index entry",
+				zap.String("collection_id", collectionID),
+				zap.String("image_id", id),
+				zap.Error(err))
+		}
+	}
+}
+
+// GetCollection retrieves a collection from S3
+func (sms *S3MetadataStore) GetCollection(ctx context.Context, id string) (*Collection, error) {
+	if !IsValidCollectionID(id) {
+		return nil, fmt.Errorf("invalid collection ID: %s", id)
+	}
+	data, err := sms.client.Get(ctx, collectionsPrefix+id+".json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get collection from S3: %v", err)
+	}
+
+	var collection Collection
+	if err := json.Unmarshal(data, &collection); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal collection: %v", err)
+	}
+	return &collection, nil
+}
+
+// ListCollections lists a user's collections from S3, oldest first; an empty user
+// ID lists every collection
+func (sms *S3MetadataStore) ListCollections(ctx context.Context, userID string) ([]*Collection, error) {
+	objects, err := sms.client.List(ctx, collectionsPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list collection objects: %v", err)
+	}
+
+	var ids []string
+	for _, obj := range objects {
+		if strings.HasSuffix(obj.Key, ".json") {
+			ids = append(ids, strings.TrimSuffix(path.Base(obj.Key), ".json"))
+		}
+	}
+	return listCollections(ctx, ids, userID, sms.GetCollection), nil
+}
+
+// DeleteCollection deletes a collection from S3
+func (sms *S3MetadataStore) DeleteCollection(ctx context.Context, id string) error {
+	if !IsValidCollectionID(id) {
+		return fmt.Errorf("invalid collection ID: %s", id)
+	}
+	collection, err := sms.GetCollection(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := sms.client.Delete(ctx, collectionsPrefix+id+".json"); err != nil {
+		return err
+	}
+	sms.removeCollectionIndex(ctx, id, collection.ImageIDs)
+	return nil
+}
+
+// ImageCollections lists the IDs of the collections holding an image from the S3 index
+func (sms *S3MetadataStore) ImageCollections(ctx context.Context, imageID string) ([]string, error) {
+	objects, err := sms.client.List(ctx, path.Join(CollectionIndexDir, imageID)+"/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list collection index: %v", err)
+	}
+
+	ids := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		ids = append(ids, path.Base(obj.Key))
+	}
+	return ids, nil
+}
+
+// RemoveFromCollections drops deleted images from every collection holding them,
+// found through the image to collection index
+func RemoveFromCollections(ctx context.Context, imageIDs []string) error {
+	deleted := make(map[string]bool, len(imageIDs))
+	affected := make(map[string]bool)
+	for _, id := range imageIDs {
+		deleted[id] = true
+		collectionIDs, err := MetadataManager.ImageCollections(ctx, id)
+		if err != nil {
+			return err
+		}
+		for _, collectionID := range collectionIDs {
+			affected[collectionID] = true
+		}
+	}
+
+	var failed int
+	for collectionID := range affected {
+		collection, err := MetadataManager.GetCollection(ctx, collectionID)
+		if err != nil {
+			logger.Warn("Failed to get collection of deleted image",
+				zap.String("collection_id", collectionID),
+				zap.Error(err))
+			failed++
+			continue
+		}
+
+		kept := make([]string, 0, len(collection.ImageIDs))
+		for _, id := range collection.ImageIDs {
+			if !deleted[id] {
+				kept = append(kept, id)
+			}
+		}
+		collection.ImageIDs = kept
+		collection.UpdatedAt = time.Now()
+		if err := MetadataManager.SaveCollection(ctx, collection); err != nil {
+			logger.Warn("Failed to remove deleted images from collection",
+				zap.String("collection_id", collection.ID),
+				zap.Error(err))
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to update %d collections", failed)
+	}
+	return nil
+}
+
+// listCollections loads the collections of file-based stores and keeps those of the user
+func listCollections(ctx context.Context, ids []string, userID string, get func(context.Context, string) (*Collection, error)) []*Collection {
+	collections := make([]*Collection, 0, len(ids))
+	for _, id := range ids {
+		collection, err := get(ctx, id)
+		if err != nil {
+			logger.Warn("Failed to get collection",
+				zap.String("collection_id", id),
+				zap.Error(err))
+			continue
+		}
+		if userID == "" || collection.UserID == userID {
+			collections = append(collections, collection)
+		}
+	}
+
+	sort.Slice(collections, func(i, j int) bool {
+		return collections[i].CreatedAt.Before(collections[j].CreatedAt)
+	})
+	return collections
+}
@@ -25,6 +25,55 @@ func InitVips(cfg *config.Config) {
 		zap.Int("workers", cfg.WorkerPoolSize))
 }
 
+// stripConvertedMetadata reports whether libvips has to drop all metadata when
+// encoding an image of a source format. libvips copies the source metadata into its
+// output, which only honours the gps policy if StripMetadata could edit the source.
+func stripConvertedMetadata(cfg *config.Config, format string) bool {
+	switch cfg.MetadataStrip {
+	case config.MetadataStripAll:
+		return true
+	case config.MetadataStripGPS:
+		return !CanStripMetadata(format)
+	}
+	return false
+}
+
+// autoOrientQuality is the encoding quality of originals turned upright, high enough
+// that the stored original stays visually lossless
+const autoOrientQuality = 95
+
+// AutoOrientWithBimg turns an image with an EXIF orientation other than 1 upright,
+// re-encoding it in its own format, and resets the orientation tag so neither
+// browsers nor later conversions rotate it again
+func AutoOrientWithBimg(data []byte, orientation int) ([]byte, error) {
+	if orientation <= 1 {
+		return data, nil
+	}
+
+	logger.Debug("Queuing auto-orient task",
+		zap.Int("input_size", len(data)),
+		zap.Int("orientation", orientation))
+
+	// Submit rotation task to worker pool and wait for result
+	return GetWorkerPool().ProcessTask(func() ([]byte, error) {
+		// libvips rotates by the EXIF orientation unless told otherwise, but keeps the tag
+		result, err := bimg.NewImage(data).Process(bimg.Options{
+			Quality: autoOrientQuality,
+		})
+		if err != nil {
+			logger.Error("Auto-orient failed", zap.Error(err))
+			return nil, fmt.Errorf("auto-orient failed: %v", err)
+		}
+		ResetEXIFOrientation(result)
+
+		logger.Debug("Auto-orient completed",
+			zap.Int("output_size", len(result)),
+			zap.Int("orientation", orientation))
+
+		return result, nil
+	})
+}
+
 // ConvertToWebPWithBimg converts image data to WebP format using bimg/libvips
 func ConvertToWebPWithBimg(data []byte, cfg *config.Config) ([]byte, error) {
 	logger.Debug("Queuing WebP conversion task",
@@ -54,9 +103,10 @@ func ConvertToWebPWithBimg(data []byte, cfg *config.Config) ([]byte, error) {
 		img := bimg.NewImage(data)
 
 		options := bimg.Options{
-			Type:    bimg.WEBP,
-			Quality: cfg.ImageQuality,
-			Speed:   cfg.Speed,
+			Type:          bimg.WEBP,
+			Quality:       cfg.ImageQuality,
+			Speed:         cfg.Speed,
+			StripMetadata: stripConvertedMetadata(cfg, imgFormat.Format),
 		}
 
 		// Perform conversion
@@ -104,9 +154,10 @@ func ConvertToAVIFWithBimg(data []byte, cfg *config.Config) ([]byte, error) {
 		img := bimg.NewImage(data)
 
 		options := bimg.Options{
-			Type:    bimg.AVIF,
-			Quality: cfg.ImageQuality,
-			Speed:   cfg.Speed,
+			Type:          bimg.AVIF,
+			Quality:       cfg.ImageQuality,
+			Speed:         cfg.Speed,
+			StripMetadata: stripConvertedMetadata(cfg, imgFormat.Format),
 		}
 
 		// Perform conversion
@@ -124,3 +175,64 @@ func ConvertToAVIFWithBimg(data []byte, cfg *config.Config) ([]byte, error) {
 		return result, nil
 	})
 }
+
+// TransformWithBimg derives a resized, cropped and re-encoded variant of image data using bimg/libvips
+func TransformWithBimg(data []byte, opts TransformOptions, cfg *config.Config) ([]byte, error) {
+	logger.Debug("Queuing transform task",
+		zap.Int("input_size", len(data)),
+		zap.Int("width", opts.Width),
+		zap.Int("height", opts.Height),
+		zap.String("fit", opts.Fit),
+		zap.String("format", opts.Format))
+
+	// Submit transform task to worker pool and wait for result
+	return GetWorkerPool().ProcessTask(func() ([]byte, error) {
+		img := bimg.NewImage(data)
+
+		size, err := img.Size()
+		if err != nil {
+			logger.Error("Failed to read image size", zap.Error(err))
+			return nil, fmt.Errorf("failed to read image size: %v", err)
+		}
+
+		width, height := opts.targetSize(size.Width, size.Height)
+
+		quality := opts.Quality
+		if quality == 0 {
+			quality = cfg.ImageQuality
+		}
+
+		options := bimg.Options{
+			Width:         width,
+			Height:        height,
+			Type:          transformFormats[opts.Format].imageType,
+			Quality:       quality,
+			Speed:         cfg.Speed,
+			StripMetadata: stripConvertedMetadata(cfg, img.Type()),
+		}
+
+		switch opts.Fit {
+		case FitCover:
+			options.Crop = true
+			options.Enlarge = true
+			options.Gravity = gravities[opts.Gravity]
+		default:
+			// Dimensions were already computed to preserve or ignore the aspect ratio
+			options.Force = true
+		}
+
+		// Perform transform
+		result, err := img.Process(options)
+		if err != nil {
+			logger.Error("Image transform failed", zap.Error(err))
+			return nil, fmt.Errorf("image transform failed: %v", err)
+		}
+
+		logger.Debug("Image transform completed",
+			zap.Int("output_size", len(result)),
+			zap.Int("width", width),
+			zap.Int("height", height))
+
+		return result, nil
+	})
+}
@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DimensionRange bounds the pixel dimensions of images. A zero bound is open.
+type DimensionRange struct {
+	MinWidth  int // Smallest width accepted
+	MaxWidth  int // Largest width accepted
+	MinHeight int // Smallest height accepted
+	MaxHeight int // Largest height accepted
+}
+
+// ParseDimensionRange builds a range from the min_width, max_width, min_height and
+// max_height query parameters. Values that are not positive integers are ignored.
+func ParseDimensionRange(minWidth, maxWidth, minHeight, maxHeight string) DimensionRange {
+	parse := func(s string) int {
+		if value, err := strconv.Atoi(s); err == nil && value > 0 {
+			return value
+		}
+		return 0
+	}
+	return DimensionRange{
+		MinWidth:  parse(minWidth),
+		MaxWidth:  parse(maxWidth),
+		MinHeight: parse(minHeight),
+		MaxHeight: parse(maxHeight),
+	}
+}
+
+// IsSet reports whether the range restricts the dimensions at all
+func (d DimensionRange) IsSet() bool {
+	return d != DimensionRange{}
+}
+
+// Contains reports whether an image of the given size lies in the range. Images of
+// unknown size never match a range that is set.
+func (d DimensionRange) Contains(width, height int) bool {
+	if !d.IsSet() {
+		return true
+	}
+	return width > 0 && height > 0 &&
+		width >= d.MinWidth && (d.MaxWidth == 0 || width <= d.MaxWidth) &&
+		height >= d.MinHeight && (d.MaxHeight == 0 || height <= d.MaxHeight)
+}
+
+// String formats the range for cache keys and logs
+func (d DimensionRange) String() string {
+	if !d.IsSet() {
+		return ""
+	}
+	return fmt.Sprintf("%dx%d-%dx%d", d.MinWidth, d.MinHeight, d.MaxWidth, d.MaxHeight)
+}
+
+// widthIndexKey is the sorted set of all images of known dimensions scored by width
+func widthIndexKey() string {
+	return RedisPrefix + "dimensions:width"
+}
+
+// heightIndexKey is the sorted set of all images of known dimensions scored by height
+func heightIndexKey() string {
+	return RedisPrefix + "dimensions:height"
+}
+
+// indexDimensions queues the dimension index updates of an image
+func indexDimensions(ctx context.Context, pipe redis.Pipeliner, metadata *ImageMetadata) {
+	if metadata.Width > 0 && metadata.Height > 0 {
+		pipe.ZAdd(ctx, widthIndexKey(), redis.Z{Score: float64(metadata.Width), Member: metadata.ID})
+		pipe.ZAdd(ctx, heightIndexKey(), redis.Z{Score: float64(metadata.Height), Member: metadata.ID})
+	} else {
+		unindexDimensions(ctx, pipe, metadata.ID)
+	}
+}
+
+// unindexDimensions queues the removal of an image from the dimension indexes
+func unindexDimensions(ctx context.Context, pipe redis.Pipeliner, id string) {
+	pipe.ZRem(ctx, widthIndexKey(), id)
+	pipe.ZRem(ctx, heightIndexKey(), id)
+}
+
+// FilterByDimensions keeps the IDs whose dimensions lie in a range, preserving their
+// order. The bounds are resolved with score ranges on the dimension indexes, so no
+// per-image metadata is read.
+func FilterByDimensions(ctx context.Context, ids []string, d DimensionRange) ([]string, error) {
+	if !d.IsSet() || len(ids) == 0 {
+		return ids, nil
+	}
+	if !IsRedisMetadataStore() {
+		return nil, fmt.Errorf("redis not enabled")
+	}
+
+	// Only the indexes of bounded sides are read
+	pipe := RedisClient.Pipeline()
+	var cmds []*redis.StringSliceCmd
+	if d.MinWidth > 0 || d.MaxWidth > 0 {
+		cmds = append(cmds, pipe.ZRangeByScore(ctx, widthIndexKey(), scoreRange(d.MinWidth, d.MaxWidth)))
+	}
+	if d.MinHeight > 0 || d.MaxHeight > 0 {
+		cmds = append(cmds, pipe.ZRangeByScore(ctx, heightIndexKey(), scoreRange(d.MinHeight, d.MaxHeight)))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to read dimension indexes: %v", err)
+	}
+
+	// An image passes when it is in range on every bounded side
+	matches := make(map[string]int)
+	for _, cmd := range cmds {
+		for _, id := range cmd.Val() {
+			matches[id]++
+		}
+	}
+
+	kept := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if matches[id] == len(cmds) {
+			kept = append(kept, id)
+		}
+	}
+	return kept, nil
+}
+
+// scoreRange returns ZRANGEBYSCORE bounds for a pair of optional integer bounds
+func scoreRange(lower, upper int) *redis.ZRangeBy {
+	bounds := &redis.ZRangeBy{Min: "-inf", Max: "+inf"}
+	if lower > 0 {
+		bounds.Min = strconv.Itoa(lower)
+	}
+	if upper > 0 {
+		bounds.Max = strconv.Itoa(upper)
+	}
+	return bounds
+}
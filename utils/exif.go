@@ -0,0 +1,448 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"strings"
+	"time"
+)
+
+// EXIFData is the subset of EXIF camera data kept in image metadata
+type EXIFData struct {
+	Make         string          `json:"make,omitempty"`         // Camera manufacturer
+	Model        string          `json:"model,omitempty"`        // Camera model
+	LensModel    string          `json:"lensModel,omitempty"`    // Lens model
+	ExposureTime string          `json:"exposureTime,omitempty"` // Exposure time in seconds, e.g. 1/250
+	FNumber      float64         `json:"fNumber,omitempty"`      // Aperture f-number
+	ISO          int             `json:"iso,omitempty"`          // ISO speed rating
+	FocalLength  float64         `json:"focalLength,omitempty"`  // Focal length in millimetres
+	Orientation  int             `json:"orientation,omitempty"`  // EXIF orientation (1-8) of the stored original, 0 if not recorded
+	DateTaken    *time.Time      `json:"dateTaken,omitempty"`    // Capture time; UTC unless the camera recorded its offset
+	GPS          *GPSCoordinates `json:"gps,omitempty"`          // Capture location
+}
+
+// GPSCoordinates is a location in decimal degrees
+type GPSCoordinates struct {
+	Latitude  float64  `json:"latitude"`           // Degrees north, negative for south
+	Longitude float64  `json:"longitude"`          // Degrees east, negative for west
+	Altitude  *float64 `json:"altitude,omitempty"` // Metres above sea level
+}
+
+// EXIF tags read from the image IFD, the EXIF sub-IFD and the GPS sub-IFD
+const (
+	exifTagMake               = 0x010F
+	exifTagModel              = 0x0110
+	exifTagOrientation        = 0x0112
+	exifTagExifIFD            = 0x8769
+	exifTagGPSIFD             = 0x8825
+	exifTagExposureTime       = 0x829A
+	exifTagFNumber            = 0x829D
+	exifTagISO                = 0x8827
+	exifTagDateTimeOriginal   = 0x9003
+	exifTagOffsetTimeOriginal = 0x9011
+	exifTagFocalLength        = 0x920A
+	exifTagLensModel          = 0xA434
+	gpsTagLatitudeRef         = 0x0001
+	gpsTagLatitude            = 0x0002
+	gpsTagLongitudeRef        = 0x0003
+	gpsTagLongitude           = 0x0004
+	gpsTagAltitudeRef         = 0x0005
+	gpsTagAltitude            = 0x0006
+)
+
+// maxIFDEntries bounds the entries read from a single IFD of a malformed file
+const maxIFDEntries = 512
+
+// exifHeader prefixes the TIFF data in JPEG APP1 segments (and some WebP files)
+var exifHeader = []byte("Exif\x00\x00")
+
+// ParseEXIF extracts the EXIF subset of a JPEG, PNG or WebP image. It returns nil
+// without an error when the image carries no EXIF data.
+func ParseEXIF(data []byte) (*EXIFData, error) {
+	tiff := findEXIFBlock(data)
+	if tiff == nil {
+		return nil, nil
+	}
+
+	exif, err := parseTIFF(tiff)
+	if err != nil {
+		return nil, err
+	}
+	if *exif == (EXIFData{}) {
+		return nil, nil
+	}
+	return exif, nil
+}
+
+// findEXIFBlock locates the TIFF-structured EXIF block of an image
+func findEXIFBlock(data []byte) []byte {
+	switch {
+	case len(data) > 4 && data[0] == 0xFF && data[1] == 0xD8:
+		return findJPEGEXIF(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return findChunk(data[8:], "eXIf", binary.BigEndian, 4)
+	case len(data) > 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return bytes.TrimPrefix(findChunk(data[12:], "EXIF", binary.LittleEndian, 0), exifHeader)
+	}
+	return nil
+}
+
+// findJPEGEXIF walks the JPEG markers up to the image data looking for the EXIF APP1 segment
+func findJPEGEXIF(data []byte) []byte {
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return nil
+		}
+		marker := data[pos+1]
+		if marker == 0xFF {
+			// Fill byte
+			pos++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			// Start of scan or end of image, metadata comes before
+			return nil
+		}
+
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil
+		}
+		segment := data[pos+4 : pos+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, exifHeader) {
+			return segment[len(exifHeader):]
+		}
+		pos += 2 + length
+	}
+	return nil
+}
+
+// findChunk returns the payload of the first chunk of a type in a PNG or RIFF chunk
+// stream. Chunks start with their length and type (PNG puts the length first, RIFF
+// the type) and PNG chunks carry a trailing CRC of crcSize bytes.
+func findChunk(data []byte, chunkType string, order binary.ByteOrder, crcSize int) []byte {
+	pos := 0
+	for pos+8 <= len(data) {
+		var length int
+		var typ string
+		if order == binary.BigEndian {
+			length = int(order.Uint32(data[pos:]))
+			typ = string(data[pos+4 : pos+8])
+		} else {
+			typ = string(data[pos : pos+4])
+			length = int(order.Uint32(data[pos+4:]))
+		}
+		if length < 0 || pos+8+length > len(data) {
+			return nil
+		}
+		if typ == chunkType {
+			return data[pos+8 : pos+8+length]
+		}
+
+		pos += 8 + length + crcSize
+		// RIFF chunks are padded to an even size
+		if crcSize == 0 && length%2 == 1 {
+			pos++
+		}
+	}
+	return nil
+}
+
+// tiffReader reads IFD entries from a TIFF-structured EXIF block
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// ifdEntry is a single tag of an IFD
+type ifdEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte // Raw value bytes, nil if out of bounds
+}
+
+// Sizes in bytes of the TIFF field types, indexed by type
+var tiffTypeSizes = [...]int{0, 1, 1, 2, 4, 8, 1, 1, 2, 4, 8, 4, 8}
+
+// parseTIFF reads the EXIF subset from a TIFF-structured block
+func parseTIFF(data []byte) (*EXIFData, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("EXIF block too short")
+	}
+
+	t := &tiffReader{data: data}
+	switch string(data[0:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid EXIF byte order")
+	}
+	if t.order.Uint16(data[2:]) != 42 {
+		return nil, fmt.Errorf("invalid TIFF header")
+	}
+
+	exif := &EXIFData{}
+	var dateTaken, offset string
+	var latRef, lonRef string
+	var lat, lon, alt []float64
+	var altBelowSea bool
+
+	for _, entry := range t.readIFD(t.order.Uint32(data[4:])) {
+		switch entry.tag {
+		case exifTagMake:
+			exif.Make = t.ascii(entry)
+		case exifTagModel:
+			exif.Model = t.ascii(entry)
+		case exifTagOrientation:
+			if v, ok := t.uint(entry); ok && v >= 1 && v <= 8 {
+				exif.Orientation = int(v)
+			}
+		case exifTagExifIFD:
+			pointer, ok := t.uint(entry)
+			if !ok {
+				continue
+			}
+			for _, sub := range t.readIFD(pointer) {
+				switch sub.tag {
+				case exifTagExposureTime:
+					exif.ExposureTime = t.exposure(sub)
+				case exifTagFNumber:
+					if v := t.rationals(sub); len(v) > 0 {
+						exif.FNumber = v[0]
+					}
+				case exifTagISO:
+					if v, ok := t.uint(sub); ok {
+						exif.ISO = int(v)
+					}
+				case exifTagDateTimeOriginal:
+					dateTaken = t.ascii(sub)
+				case exifTagOffsetTimeOriginal:
+					offset = t.ascii(sub)
+				case exifTagFocalLength:
+					if v := t.rationals(sub); len(v) > 0 {
+						exif.FocalLength = v[0]
+					}
+				case exifTagLensModel:
+					exif.LensModel = t.ascii(sub)
+				}
+			}
+		case exifTagGPSIFD:
+			pointer, ok := t.uint(entry)
+			if !ok {
+				continue
+			}
+			for _, sub := range t.readIFD(pointer) {
+				switch sub.tag {
+				case gpsTagLatitudeRef:
+					latRef = t.ascii(sub)
+				case gpsTagLatitude:
+					lat = t.rationals(sub)
+				case gpsTagLongitudeRef:
+					lonRef = t.ascii(sub)
+				case gpsTagLongitude:
+					lon = t.rationals(sub)
+				case gpsTagAltitudeRef:
+					altBelowSea = len(sub.value) > 0 && sub.value[0] == 1
+				case gpsTagAltitude:
+					alt = t.rationals(sub)
+				}
+			}
+		}
+	}
+
+	if dateTaken != "" {
+		exif.DateTaken = parseEXIFTime(dateTaken, offset)
+	}
+
+	if len(lat) == 3 && len(lon) == 3 {
+		gps := &GPSCoordinates{
+			Latitude:  lat[0] + lat[1]/60 + lat[2]/3600,
+			Longitude: lon[0] + lon[1]/60 + lon[2]/3600,
+		}
+		if strings.EqualFold(latRef, "S") {
+			gps.Latitude = -gps.Latitude
+		}
+		if strings.EqualFold(lonRef, "W") {
+			gps.Longitude = -gps.Longitude
+		}
+		if len(alt) == 1 {
+			altitude := alt[0]
+			if altBelowSea {
+				altitude = -altitude
+			}
+			gps.Altitude = &altitude
+		}
+		// Cameras without a fix write zeros
+		if gps.Latitude != 0 || gps.Longitude != 0 {
+			exif.GPS = gps
+		}
+	}
+
+	return exif, nil
+}
+
+// readIFD reads the entries of the IFD at an offset, returning nil if it is out of bounds
+func (t *tiffReader) readIFD(offset uint32) []ifdEntry {
+	if uint64(offset)+2 > uint64(len(t.data)) {
+		return nil
+	}
+	pos := int(offset)
+	count := int(t.order.Uint16(t.data[pos:]))
+	if count > maxIFDEntries {
+		return nil
+	}
+	pos += 2
+
+	entries := make([]ifdEntry, 0, count)
+	for i := 0; i < count && pos+12 <= len(t.data); i, pos = i+1, pos+12 {
+		entry := ifdEntry{
+			tag:   t.order.Uint16(t.data[pos:]),
+			typ:   t.order.Uint16(t.data[pos+2:]),
+			count: t.order.Uint32(t.data[pos+4:]),
+		}
+		if int(entry.typ) >= len(tiffTypeSizes) || entry.typ == 0 {
+			continue
+		}
+
+		// Values of up to four bytes are stored inline, larger ones at an offset
+		size := uint64(tiffTypeSizes[entry.typ]) * uint64(entry.count)
+		if size <= 4 {
+			entry.value = t.data[pos+8 : pos+8+int(size)]
+		} else if valueOffset := uint64(t.order.Uint32(t.data[pos+8:])); valueOffset+size <= uint64(len(t.data)) {
+			entry.value = t.data[valueOffset : valueOffset+size]
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// ascii decodes an ASCII value, trimming padding
+func (t *tiffReader) ascii(entry ifdEntry) string {
+	if entry.typ != 2 {
+		return ""
+	}
+	value := string(entry.value)
+	if i := strings.IndexByte(value, 0); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value)
+}
+
+// uint decodes the first value of a BYTE, SHORT or LONG entry
+func (t *tiffReader) uint(entry ifdEntry) (uint32, bool) {
+	switch {
+	case entry.typ == 1 && len(entry.value) >= 1:
+		return uint32(entry.value[0]), true
+	case entry.typ == 3 && len(entry.value) >= 2:
+		return uint32(t.order.Uint16(entry.value)), true
+	case entry.typ == 4 && len(entry.value) >= 4:
+		return t.order.Uint32(entry.value), true
+	}
+	return 0, false
+}
+
+// rationals decodes a RATIONAL or SRATIONAL entry, skipping values with a zero denominator
+func (t *tiffReader) rationals(entry ifdEntry) []float64 {
+	if entry.typ != 5 && entry.typ != 10 {
+		return nil
+	}
+
+	values := make([]float64, 0, len(entry.value)/8)
+	for pos := 0; pos+8 <= len(entry.value); pos += 8 {
+		num := t.order.Uint32(entry.value[pos:])
+		den := t.order.Uint32(entry.value[pos+4:])
+		if den == 0 {
+			return nil
+		}
+		if entry.typ == 10 {
+			values = append(values, float64(int32(num))/float64(int32(den)))
+		} else {
+			values = append(values, float64(num)/float64(den))
+		}
+	}
+	return values
+}
+
+// exposure formats an exposure time the way cameras display it: 1/250 or 2.5
+func (t *tiffReader) exposure(entry ifdEntry) string {
+	values := t.rationals(entry)
+	if len(values) == 0 || values[0] <= 0 {
+		return ""
+	}
+	if seconds := values[0]; seconds < 1 {
+		return fmt.Sprintf("1/%d", int(math.Round(1/seconds)))
+	}
+	return fmt.Sprintf("%g", math.Round(values[0]*10)/10)
+}
+
+// OrientedSize returns the displayed size of an image with an EXIF orientation.
+// Orientations 5 to 8 turn the image by a quarter, swapping width and height.
+func OrientedSize(width, height, orientation int) (int, int) {
+	if orientation >= 5 && orientation <= 8 {
+		return height, width
+	}
+	return width, height
+}
+
+// ResetEXIFOrientation sets the EXIF orientation of an image to 1 in place, for
+// images whose pixels have been turned upright. Images without the tag are left alone.
+func ResetEXIFOrientation(data []byte) {
+	tiff := findEXIFBlock(data)
+	if len(tiff) < 8 {
+		return
+	}
+
+	t := &tiffReader{data: tiff}
+	switch string(tiff[0:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return
+	}
+
+	for _, entry := range t.readIFD(t.order.Uint32(tiff[4:])) {
+		if entry.tag == exifTagOrientation && entry.typ == 3 && len(entry.value) >= 2 {
+			t.order.PutUint16(entry.value, 1)
+			break
+		}
+	}
+
+	// PNG chunks are checksummed, so the edited eXIf chunk needs a new CRC
+	if bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")) {
+		for pos := 8; pos+12 <= len(data); {
+			length := int(binary.BigEndian.Uint32(data[pos:]))
+			if length < 0 || pos+12+length > len(data) {
+				return
+			}
+			if string(data[pos+4:pos+8]) == "eXIf" {
+				binary.BigEndian.PutUint32(data[pos+8+length:], crc32.ChecksumIEEE(data[pos+4:pos+8+length]))
+				return
+			}
+			pos += 12 + length
+		}
+	}
+}
+
+// parseEXIFTime parses an EXIF timestamp, applying its recorded UTC offset if any.
+// Without an offset the local time of the camera is unknown and UTC is assumed.
+func parseEXIFTime(value, offset string) *time.Time {
+	layout := "2006:01:02 15:04:05"
+	if offset != "" {
+		value += offset
+		layout += "-07:00"
+	}
+	taken, err := time.Parse(layout, value)
+	if err != nil || taken.Year() < 1900 {
+		return nil
+	}
+	return &taken
+}
@@ -0,0 +1,235 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/Yuri-NagaSaki/ImageFlow/config"
+)
+
+// xmpHeader prefixes the XMP packet in JPEG APP1 segments
+var xmpHeader = []byte("http://ns.adobe.com/xap/1.0/\x00")
+
+// CanStripMetadata reports whether StripMetadata can edit the metadata of a format.
+// Conversions of other formats have to drop their metadata altogether.
+func CanStripMetadata(format string) bool {
+	switch format {
+	case "jpeg", "png", "webp", "gif":
+		// GIFs carry no EXIF data
+		return true
+	}
+	return false
+}
+
+// StripMetadata removes metadata from a JPEG, PNG or WebP image according to a
+// config.MetadataStrip policy, without re-encoding the pixels. With the gps policy
+// the GPS sub-IFD of the EXIF block is erased in place, keeping all other camera
+// data; XMP packets are dropped when they repeat the location. With the all policy
+// the EXIF and XMP blocks are removed. Other formats are returned unchanged.
+func StripMetadata(data []byte, policy string) []byte {
+	if policy != config.MetadataStripGPS && policy != config.MetadataStripAll {
+		return data
+	}
+
+	switch {
+	case len(data) > 4 && data[0] == 0xFF && data[1] == 0xD8:
+		return stripJPEGMetadata(data, policy)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return stripPNGMetadata(data, policy)
+	case len(data) > 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return stripWebPMetadata(data, policy)
+	}
+	return data
+}
+
+// keepXMP reports whether an XMP packet survives a policy
+func keepXMP(packet []byte, policy string) bool {
+	return policy == config.MetadataStripGPS && !bytes.Contains(packet, []byte("GPSL"))
+}
+
+// stripJPEGMetadata rewrites the marker segments of a JPEG that precede the image data
+func stripJPEGMetadata(data []byte, policy string) []byte {
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+
+	pos := 2
+	for pos+4 <= len(data) {
+		marker := data[pos+1]
+		if data[pos] != 0xFF || marker == 0xDA || marker == 0xD9 {
+			// Entropy-coded data follows, copy the rest untouched
+			break
+		}
+		if marker == 0xFF {
+			out = append(out, 0xFF)
+			pos++
+			continue
+		}
+
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			break
+		}
+		end := pos + 2 + length
+		segment := data[pos+4 : end]
+
+		if marker == 0xE1 {
+			switch {
+			case bytes.HasPrefix(segment, exifHeader):
+				if policy == config.MetadataStripAll {
+					pos = end
+					continue
+				}
+				start := len(out) + 4 + len(exifHeader)
+				out = append(out, data[pos:end]...)
+				eraseGPS(out[start:])
+				pos = end
+				continue
+			case bytes.HasPrefix(segment, xmpHeader) && !keepXMP(segment, policy):
+				pos = end
+				continue
+			}
+		}
+
+		out = append(out, data[pos:end]...)
+		pos = end
+	}
+
+	return append(out, data[pos:]...)
+}
+
+// stripPNGMetadata rewrites the eXIf and XMP chunks of a PNG
+func stripPNGMetadata(data []byte, policy string) []byte {
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:8]...)
+
+	pos := 8
+	for pos+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		if length < 0 || pos+12+length > len(data) {
+			break
+		}
+		end := pos + 12 + length
+		chunkType := string(data[pos+4 : pos+8])
+		payload := data[pos+8 : pos+8+length]
+
+		switch {
+		case chunkType == "eXIf":
+			if policy == config.MetadataStripAll {
+				pos = end
+				continue
+			}
+			start := len(out)
+			out = append(out, data[pos:end]...)
+			eraseGPS(out[start+8 : start+8+length])
+			// The chunk CRC covers the type and the edited payload
+			binary.BigEndian.PutUint32(out[start+8+length:], crc32.ChecksumIEEE(out[start+4:start+8+length]))
+			pos = end
+			continue
+		case chunkType == "iTXt" && bytes.HasPrefix(payload, []byte("XML:com.adobe.xmp\x00")):
+			// The packet may be compressed, so it is only kept when nothing is stripped
+			pos = end
+			continue
+		}
+
+		out = append(out, data[pos:end]...)
+		pos = end
+	}
+
+	return append(out, data[pos:]...)
+}
+
+// stripWebPMetadata rewrites the EXIF and XMP chunks of a WebP, keeping the VP8X
+// feature flags and the RIFF size in step
+func stripWebPMetadata(data []byte, policy string) []byte {
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:12]...)
+
+	const (
+		vp8xXMPFlag  = 0x04
+		vp8xEXIFFlag = 0x08
+	)
+	vp8xFlags := -1
+	var dropped byte
+
+	pos := 12
+	for pos+8 <= len(data) {
+		chunkType := string(data[pos : pos+4])
+		length := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := pos + 8 + length + length%2
+		if length < 0 || end > len(data) {
+			break
+		}
+		payload := data[pos+8 : pos+8+length]
+
+		switch chunkType {
+		case "VP8X":
+			vp8xFlags = len(out) + 8
+		case "EXIF":
+			if policy == config.MetadataStripAll {
+				dropped |= vp8xEXIFFlag
+				pos = end
+				continue
+			}
+			start := len(out) + 8
+			out = append(out, data[pos:end]...)
+			block := out[start : start+length]
+			eraseGPS(bytes.TrimPrefix(block, exifHeader))
+			pos = end
+			continue
+		case "XMP ":
+			if !keepXMP(payload, policy) {
+				dropped |= vp8xXMPFlag
+				pos = end
+				continue
+			}
+		}
+
+		out = append(out, data[pos:end]...)
+		pos = end
+	}
+	out = append(out, data[pos:]...)
+
+	if vp8xFlags >= 0 && vp8xFlags < len(out) {
+		out[vp8xFlags] &^= dropped
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out
+}
+
+// eraseGPS zeroes the GPS sub-IFD of a TIFF-structured EXIF block in place, leaving
+// an empty IFD behind so no offsets in the block change
+func eraseGPS(tiff []byte) {
+	if len(tiff) < 8 {
+		return
+	}
+
+	t := &tiffReader{data: tiff}
+	switch string(tiff[0:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return
+	}
+
+	for _, entry := range t.readIFD(t.order.Uint32(tiff[4:])) {
+		if entry.tag != exifTagGPSIFD {
+			continue
+		}
+		pointer, ok := t.uint(entry)
+		if !ok || uint64(pointer)+2 > uint64(len(tiff)) {
+			return
+		}
+
+		// Zero the values stored outside the entries, then the entries themselves
+		for _, gps := range t.readIFD(pointer) {
+			clear(gps.value)
+		}
+		start := int(pointer)
+		end := min(start+2+12*int(t.order.Uint16(tiff[start:])), len(tiff))
+		clear(tiff[start:end])
+		return
+	}
+}
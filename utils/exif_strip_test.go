@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"github.com/Yuri-NagaSaki/ImageFlow/config"
+)
+
+// testTIFF is a little-endian EXIF block with Make, Model and a GPS sub-IFD
+// locating the image at 48°51'30"N 2°17'40"E
+func testTIFF() []byte {
+	le := binary.LittleEndian
+	var b []byte
+	entry := func(tag, typ uint16, count uint32, value []byte) {
+		b = le.AppendUint16(b, tag)
+		b = le.AppendUint16(b, typ)
+		b = le.AppendUint32(b, count)
+		b = append(b, append(value, make([]byte, 4-len(value))...)...)
+	}
+	offset := func(v uint32) []byte { return le.AppendUint32(nil, v) }
+	rationals := func(values ...uint32) []byte {
+		var r []byte
+		for _, v := range values {
+			r = le.AppendUint32(le.AppendUint32(r, v), 1)
+		}
+		return r
+	}
+
+	b = append(b, "II*\x00"...)
+	b = le.AppendUint32(b, 8)
+
+	// IFD0 at 8, its Make string at 50 and the GPS IFD at 56
+	b = le.AppendUint16(b, 3)
+	entry(exifTagMake, 2, 6, offset(50))
+	entry(exifTagModel, 2, 4, []byte("EOS\x00"))
+	entry(exifTagGPSIFD, 4, 1, offset(56))
+	b = le.AppendUint32(b, 0)
+	b = append(b, "Canon\x00"...)
+
+	// GPS IFD at 56, its coordinates at 110 and 134
+	b = le.AppendUint16(b, 4)
+	entry(gpsTagLatitudeRef, 2, 2, []byte("N"))
+	entry(gpsTagLatitude, 5, 3, offset(110))
+	entry(gpsTagLongitudeRef, 2, 2, []byte("E"))
+	entry(gpsTagLongitude, 5, 3, offset(134))
+	b = le.AppendUint32(b, 0)
+	b = append(b, rationals(48, 51, 30)...)
+	b = append(b, rationals(2, 17, 40)...)
+	return b
+}
+
+// pngChunk encodes a PNG chunk with its CRC
+func pngChunk(chunkType string, payload []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	b = append(b, chunkType...)
+	b = append(b, payload...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b[4:]))
+}
+
+// riffChunk encodes a RIFF chunk, padded to an even size
+func riffChunk(chunkType string, payload []byte) []byte {
+	b := append([]byte(chunkType), binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))...)
+	b = append(b, payload...)
+	if len(payload)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func TestStripMetadata(t *testing.T) {
+	tiff := testTIFF()
+
+	jpeg := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+	jpeg = binary.BigEndian.AppendUint16(jpeg, uint16(2+len(exifHeader)+len(tiff)))
+	jpeg = append(jpeg, exifHeader...)
+	jpeg = append(jpeg, tiff...)
+	jpeg = append(jpeg, 0xFF, 0xDA, 0x00, 0x02, 0x12, 0x34, 0xFF, 0xD9)
+
+	png := []byte("\x89PNG\r\n\x1a\n")
+	png = append(png, pngChunk("IHDR", []byte{0, 0, 0, 1, 0, 0, 0, 1, 8, 0, 0, 0, 0})...)
+	png = append(png, pngChunk("eXIf", tiff)...)
+	png = append(png, pngChunk("IDAT", []byte{0x78, 0x9C, 0x63, 0x60, 0x00, 0x00, 0x00, 0x02, 0x00, 0x01})...)
+	png = append(png, pngChunk("IEND", nil)...)
+
+	var chunks []byte
+	chunks = append(chunks, riffChunk("VP8X", []byte{0x08, 0, 0, 0, 0, 0, 0, 0, 0, 0})...)
+	chunks = append(chunks, riffChunk("VP8L", []byte{0x2F, 0, 0, 0, 0x10})...)
+	chunks = append(chunks, riffChunk("EXIF", append(append([]byte{}, exifHeader...), tiff...))...)
+	webp := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(4+len(chunks)))...)
+	webp = append(webp, "WEBP"...)
+	webp = append(webp, chunks...)
+
+	tests := []struct {
+		name  string
+		input []byte
+		valid func(t *testing.T, out []byte)
+	}{
+		{"jpeg", jpeg, func(t *testing.T, out []byte) {
+			if !bytes.HasSuffix(out, jpeg[len(jpeg)-8:]) {
+				t.Error("image data after the metadata segments was not preserved")
+			}
+		}},
+		{"png", png, validPNGChunks},
+		{"webp", webp, validWebPChunks},
+	}
+
+	for _, tt := range tests {
+		if exif, err := ParseEXIF(tt.input); err != nil || exif == nil || exif.GPS == nil {
+			t.Fatalf("%s: test input has no GPS data: %+v, %v", tt.name, exif, err)
+		}
+
+		for _, policy := range []string{config.MetadataStripGPS, config.MetadataStripAll} {
+			t.Run(tt.name+"/"+policy, func(t *testing.T) {
+				out := StripMetadata(bytes.Clone(tt.input), policy)
+				tt.valid(t, out)
+
+				exif, err := ParseEXIF(out)
+				if err != nil {
+					t.Fatalf("ParseEXIF of stripped image: %v", err)
+				}
+				if policy == config.MetadataStripAll {
+					if exif != nil {
+						t.Errorf("EXIF data survived: %+v", exif)
+					}
+					return
+				}
+				if exif == nil {
+					t.Fatal("EXIF data was removed")
+				}
+				if exif.GPS != nil {
+					t.Errorf("GPS data survived: %+v", exif.GPS)
+				}
+				if exif.Make != "Canon" || exif.Model != "EOS" {
+					t.Errorf("camera data not preserved: make %q, model %q", exif.Make, exif.Model)
+				}
+			})
+		}
+	}
+}
+
+// validPNGChunks checks that every chunk of a PNG is in bounds with a matching CRC
+func validPNGChunks(t *testing.T, out []byte) {
+	pos := 8
+	for pos < len(out) {
+		if pos+12 > len(out) {
+			t.Fatalf("truncated chunk at %d", pos)
+		}
+		length := int(binary.BigEndian.Uint32(out[pos:]))
+		end := pos + 12 + length
+		if end > len(out) {
+			t.Fatalf("chunk at %d overruns the image", pos)
+		}
+		if crc := binary.BigEndian.Uint32(out[end-4:]); crc != crc32.ChecksumIEEE(out[pos+4:end-4]) {
+			t.Errorf("%s chunk has an invalid CRC", out[pos+4:pos+8])
+		}
+		pos = end
+	}
+}
+
+// validWebPChunks checks the RIFF size and that every chunk of a WebP is in bounds
+func validWebPChunks(t *testing.T, out []byte) {
+	if size := binary.LittleEndian.Uint32(out[4:]); int(size) != len(out)-8 {
+		t.Errorf("RIFF size %d, want %d", size, len(out)-8)
+	}
+	pos := 12
+	for pos < len(out) {
+		if pos+8 > len(out) {
+			t.Fatalf("truncated chunk at %d", pos)
+		}
+		length := int(binary.LittleEndian.Uint32(out[pos+4:]))
+		pos += 8 + length + length%2
+		if pos > len(out) {
+			t.Fatalf("%s chunk overruns the image", out[pos-8-length-length%2:][:4])
+		}
+	}
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"image"
 	_ "image/gif"  // Register GIF format
@@ -32,6 +33,22 @@ var SupportedImageExtensions = []string{".jpg", ".jpeg", ".png", ".gif", ".webp"
 // Global random source with proper seeding
 var globalRand = rand.New(rand.NewSource(time.Now().UnixNano()))
 
+// StoredImageDimensions reads the pixel dimensions of a stored image. Only the
+// image header is decoded, so the rest of the object is never downloaded.
+func StoredImageDimensions(ctx context.Context, key string) (int, int, error) {
+	body, _, err := Storage.Open(ctx, key)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer body.Close()
+
+	config, _, err := image.DecodeConfig(body)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to decode image header: %v", err)
+	}
+	return config.Width, config.Height, nil
+}
+
 // DetectImageFormat detects the format of an image from its binary data
 func DetectImageFormat(data []byte) (ImageFormatInfo, error) {
 	// Create a reader from the data
@@ -4,9 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Yuri-NagaSaki/ImageFlow/config"
@@ -19,22 +22,104 @@ import (
 
 // ImageMetadata stores metadata information for images
 type ImageMetadata struct {
-	ID           string           `json:"id"`           // Image ID (without extension)
-	UserID       string           `json:"user_id"`      // User ID who owns this image
-	OriginalName string           `json:"originalName"` // Original filename
-	UploadTime   time.Time        `json:"uploadTime"`   // Upload timestamp
-	ExpiryTime   time.Time        `json:"expiryTime"`   // Expiry timestamp (if set)
-	Format       string           `json:"format"`       // Original format
-	Orientation  string           `json:"orientation"`  // Image orientation
-	Tags         []string         `json:"tags"`         // Image tags for categorization
-	Sizes        map[string]int64 `json:"sizes"`        // File sizes for different formats
-	Paths        struct {
+	ID             string           `json:"id"`                    // Image ID (without extension)
+	UserID         string           `json:"user_id"`               // User ID who owns this image
+	OriginalName   string           `json:"originalName"`          // Original filename
+	UploadTime     time.Time        `json:"uploadTime"`            // Upload timestamp
+	ExpiryTime     time.Time        `json:"expiryTime"`            // Expiry timestamp (if set)
+	Format         string           `json:"format"`                // Original format
+	Orientation    string           `json:"orientation"`           // Image orientation
+	Width          int              `json:"width,omitempty"`       // Width of the original in pixels
+	Height         int              `json:"height,omitempty"`      // Height of the original in pixels
+	AspectRatio    float64          `json:"aspectRatio,omitempty"` // Width divided by height, 0 if unknown
+	EXIF           *EXIFData        `json:"exif,omitempty"`        // Camera data parsed from the original
+	Tags           []string         `json:"tags"`                  // Image tags for categorization
+	Visibility     string           `json:"visibility"`            // public, unlisted or private (empty means public)
+	ContentHash    string           `json:"contentHash"`           // SHA-256 of the uploaded bytes (hex)
+	PerceptualHash string           `json:"perceptualHash"`        // Difference hash of the decoded image (hex), empty for GIFs
+	Weight         float64          `json:"weight,omitempty"`      // Relative weight in random selection (0 means the default of 1)
+	Sizes          map[string]int64 `json:"sizes"`                 // File sizes for different formats
+	Variants       map[string]int64 `json:"variants,omitempty"`    // Cached derived variants (storage key -> size)
+	Paths          struct {
 		Original string `json:"original"` // Path to original image
 		WebP     string `json:"webp"`     // Path to WebP format
 		AVIF     string `json:"avif"`     // Path to AVIF format
+		// Paths to WebP thumbnails keyed by variant name (e.g. thumb_150)
+		Thumbnails map[string]string `json:"thumbnails,omitempty"`
 	} `json:"paths"`
 }
 
+// VariantPath returns the storage key of a stored rendition: original, webp, avif or a thumbnail variant
+func (m *ImageMetadata) VariantPath(variant string) (string, bool) {
+	switch variant {
+	case "", "original":
+		return m.Paths.Original, m.Paths.Original != ""
+	case "webp", "avif":
+		key := m.Paths.WebP
+		if variant == "avif" {
+			key = m.Paths.AVIF
+		}
+		if key == "" {
+			// GIFs and failed conversions are served from the original file
+			key = m.Paths.Original
+		}
+		return key, key != ""
+	}
+
+	key, ok := m.Paths.Thumbnails[variant]
+	return key, ok
+}
+
+// ETag returns a strong entity tag for a stored rendition of the image. It is derived
+// from the content hash of the upload plus the part of the key that distinguishes the
+// rendition, and is empty for images stored before content hashes were recorded.
+func (m *ImageMetadata) ETag(key string) string {
+	if m.ContentHash == "" || key == "" {
+		return ""
+	}
+	rendition := strings.TrimPrefix(path.Base(key), m.ID)
+	if !strings.HasPrefix(rendition, ".") && !strings.HasPrefix(rendition, "_") {
+		// Cached transform variants are named by their options hash
+		rendition = "-" + rendition
+	}
+	return fmt.Sprintf("\"%s%s\"", m.ContentHash, rendition)
+}
+
+// Ratio returns the aspect ratio of the image, derived from its dimensions for
+// images stored before the ratio was recorded
+func (m *ImageMetadata) Ratio() float64 {
+	if m.AspectRatio > 0 {
+		return m.AspectRatio
+	}
+	return AspectRatio(m.Width, m.Height)
+}
+
+// MaxRandomWeight caps the weight an image may be given in random selection
+const MaxRandomWeight = 1000
+
+// RandomWeight returns the relative weight of the image in random selection
+func (m *ImageMetadata) RandomWeight() float64 {
+	if m.Weight <= 0 {
+		return 1
+	}
+	return m.Weight
+}
+
+// NormalizeTags trims tags and drops empty and duplicate entries, keeping their order
+func NormalizeTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
 // MetadataStore defines the interface for metadata storage operations
 type MetadataStore interface {
 	SaveMetadata(ctx context.Context, metadata *ImageMetadata) error
@@ -43,10 +128,24 @@ type MetadataStore interface {
 	ListExpiredImages(ctx context.Context) ([]*ImageMetadata, error)
 	DeleteMetadata(ctx context.Context, id string) error
 	GetAllMetadata(ctx context.Context) ([]*ImageMetadata, error)
+	// Find a user's image by the SHA-256 of its original bytes, returns nil if there is none
+	FindByContentHash(ctx context.Context, userID, contentHash string) (*ImageMetadata, error)
 	// Verify user ownership of an image
 	VerifyImageOwnership(ctx context.Context, imageID, userID string) error
+	// Change the cached variant records of an existing image without rewriting its other fields
+	UpdateVariants(ctx context.Context, id string, update func(map[string]int64)) error
+	// Collections of images, listed oldest first; an empty user ID lists all of them
+	SaveCollection(ctx context.Context, collection *Collection) error
+	GetCollection(ctx context.Context, id string) (*Collection, error)
+	ListCollections(ctx context.Context, userID string) ([]*Collection, error)
+	DeleteCollection(ctx context.Context, id string) error
+	// List the IDs of the collections holding an image
+	ImageCollections(ctx context.Context, imageID string) ([]string, error)
 }
 
+// fileVariantsMu serializes variant record updates of the file-based metadata stores
+var fileVariantsMu sync.Mutex
+
 // LocalMetadataStore implements metadata storage for local filesystem
 type LocalMetadataStore struct {
 	BasePath string
@@ -76,6 +175,17 @@ func (lms *LocalMetadataStore) SaveMetadata(ctx context.Context, metadata *Image
 		return fmt.Errorf("failed to write metadata file: %v", err)
 	}
 
+	// Index content hash for per-user deduplication
+	if metadata.ContentHash != "" {
+		hashPath := filepath.Join(lms.BasePath, filepath.FromSlash(contentHashPath(metadata.UserID, metadata.ContentHash)))
+		if err := os.MkdirAll(filepath.Dir(hashPath), 0755); err != nil {
+			return fmt.Errorf("failed to create content hash directory: %v", err)
+		}
+		if err := os.WriteFile(hashPath, []byte(metadata.ID), 0644); err != nil {
+			return fmt.Errorf("failed to write content hash index: %v", err)
+		}
+	}
+
 	logger.Info("Metadata saved successfully",
 		zap.String("image_id", metadata.ID),
 		zap.String("path", metadataPath))
@@ -99,6 +209,30 @@ func (lms *LocalMetadataStore) GetMetadata(ctx context.Context, id string) (*Ima
 	return &metadata, nil
 }
 
+// UpdateVariants changes the cached variant records of an existing image
+func (lms *LocalMetadataStore) UpdateVariants(ctx context.Context, id string, update func(map[string]int64)) error {
+	fileVariantsMu.Lock()
+	defer fileVariantsMu.Unlock()
+
+	metadata, err := lms.GetMetadata(ctx, id)
+	if err != nil {
+		return err
+	}
+	if metadata.Variants == nil {
+		metadata.Variants = make(map[string]int64)
+	}
+	update(metadata.Variants)
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(lms.BasePath, "metadata", id+".json"), data, 0644); err != nil {
+		return fmt.Errorf("failed to write metadata file: %v", err)
+	}
+	return nil
+}
+
 // ListExpiredImages lists all expired images
 func (lms *LocalMetadataStore) ListExpiredImages(ctx context.Context) ([]*ImageMetadata, error) {
 	metadataDir := filepath.Join(lms.BasePath, "metadata")
@@ -145,6 +279,14 @@ func (lms *LocalMetadataStore) ListExpiredImages(ctx context.Context) ([]*ImageM
 
 // DeleteMetadata deletes image metadata
 func (lms *LocalMetadataStore) DeleteMetadata(ctx context.Context, id string) error {
+	// Remove from content hash index unless it already points at another image
+	if metadata, err := lms.GetMetadata(ctx, id); err == nil && metadata.ContentHash != "" {
+		hashPath := filepath.Join(lms.BasePath, filepath.FromSlash(contentHashPath(metadata.UserID, metadata.ContentHash)))
+		if indexedID, err := os.ReadFile(hashPath); err == nil && string(indexedID) == id {
+			os.Remove(hashPath)
+		}
+	}
+
 	metadataPath := filepath.Join(lms.BasePath, "metadata", id+".json")
 	return os.Remove(metadataPath)
 }
@@ -184,6 +326,25 @@ func (lms *LocalMetadataStore) VerifyImageOwnership(ctx context.Context, imageID
 	return nil
 }
 
+// FindByContentHash finds a user's image by content hash using the hash index files
+func (lms *LocalMetadataStore) FindByContentHash(ctx context.Context, userID, contentHash string) (*ImageMetadata, error) {
+	hashPath := filepath.Join(lms.BasePath, filepath.FromSlash(contentHashPath(userID, contentHash)))
+	id, err := os.ReadFile(hashPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read content hash index: %v", err)
+	}
+
+	metadata, err := lms.GetMetadata(ctx, string(id))
+	if err != nil || metadata.ContentHash != contentHash || metadata.UserID != userID {
+		// Stale index entry, e.g. metadata removed outside DeleteMetadata
+		os.Remove(hashPath)
+		return nil, nil
+	}
+	return metadata, nil
+}
+
 // GetAllMetadata retrieves all image metadata from local storage
 func (lms *LocalMetadataStore) GetAllMetadata(ctx context.Context) ([]*ImageMetadata, error) {
 	var allMetadata []*ImageMetadata
@@ -250,6 +411,14 @@ func (sms *S3MetadataStore) SaveMetadata(ctx context.Context, metadata *ImageMet
 		return fmt.Errorf("failed to store metadata in S3: %v", err)
 	}
 
+	// Index content hash for per-user deduplication
+	if metadata.ContentHash != "" {
+		// Entries map hashes to image IDs, private images included, so they are never public
+		if err := sms.client.StoreWithACL(ctx, contentHashPath(metadata.UserID, metadata.ContentHash), []byte(metadata.ID), true); err != nil {
+			return fmt.Errorf("failed to store content hash index in S3: %v", err)
+		}
+	}
+
 	logger.Info("Metadata saved to S3",
 		zap.String("image_id", metadata.ID),
 		zap.String("key", key))
@@ -273,6 +442,30 @@ func (sms *S3MetadataStore) GetMetadata(ctx context.Context, id string) (*ImageM
 	return &metadata, nil
 }
 
+// UpdateVariants changes the cached variant records of an existing image in S3
+func (sms *S3MetadataStore) UpdateVariants(ctx context.Context, id string, update func(map[string]int64)) error {
+	fileVariantsMu.Lock()
+	defer fileVariantsMu.Unlock()
+
+	metadata, err := sms.GetMetadata(ctx, id)
+	if err != nil {
+		return err
+	}
+	if metadata.Variants == nil {
+		metadata.Variants = make(map[string]int64)
+	}
+	update(metadata.Variants)
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %v", err)
+	}
+	if err := sms.client.Store(ctx, sms.prefix+id+".json", data); err != nil {
+		return fmt.Errorf("failed to store metadata in S3: %v", err)
+	}
+	return nil
+}
+
 // ListExpiredImages lists all expired images in S3
 func (sms *S3MetadataStore) ListExpiredImages(ctx context.Context) ([]*ImageMetadata, error) {
 	paginator := s3.NewListObjectsV2Paginator(S3Client, &s3.ListObjectsV2Input{
@@ -321,6 +514,18 @@ func (sms *S3MetadataStore) ListExpiredImages(ctx context.Context) ([]*ImageMeta
 
 // DeleteMetadata deletes image metadata from S3
 func (sms *S3MetadataStore) DeleteMetadata(ctx context.Context, id string) error {
+	// Remove from content hash index unless it already points at another image
+	if metadata, err := sms.GetMetadata(ctx, id); err == nil && metadata.ContentHash != "" {
+		hashKey := contentHashPath(metadata.UserID, metadata.ContentHash)
+		if indexedID, err := sms.client.Get(ctx, hashKey); err == nil && string(indexedID) == id {
+			if err := sms.client.Delete(ctx, hashKey); err != nil {
+				logger.Warn("Failed to remove from content hash index",
+					zap.String("id", id),
+					zap.Error(err))
+			}
+		}
+	}
+
 	key := sms.prefix + id + ".json"
 	return sms.client.Delete(ctx, key)
 }
@@ -359,6 +564,24 @@ func (s3ms *S3MetadataStore) VerifyImageOwnership(ctx context.Context, imageID,
 	return nil
 }
 
+// FindByContentHash finds a user's image by content hash using the hash index objects in S3
+func (s3ms *S3MetadataStore) FindByContentHash(ctx context.Context, userID, contentHash string) (*ImageMetadata, error) {
+	hashKey := contentHashPath(userID, contentHash)
+	id, err := s3ms.client.Get(ctx, hashKey)
+	if err != nil {
+		// No index entry, the image has not been uploaded by this user
+		return nil, nil
+	}
+
+	metadata, err := s3ms.GetMetadata(ctx, string(id))
+	if err != nil || metadata.ContentHash != contentHash || metadata.UserID != userID {
+		// Stale index entry, e.g. metadata removed outside DeleteMetadata
+		s3ms.client.Delete(ctx, hashKey)
+		return nil, nil
+	}
+	return metadata, nil
+}
+
 // GetAllMetadata retrieves all image metadata from S3
 func (s3ms *S3MetadataStore) GetAllMetadata(ctx context.Context) ([]*ImageMetadata, error) {
 	var allMetadata []*ImageMetadata
@@ -396,6 +619,16 @@ func (s3ms *S3MetadataStore) GetAllMetadata(ctx context.Context) ([]*ImageMetada
 	return allMetadata, nil
 }
 
+// contentHashPath returns the slash-separated key of the hash index entry that maps
+// a user's content hash to an image ID in the file-based stores
+func contentHashPath(userID, contentHash string) string {
+	owner := url.PathEscape(userID)
+	if owner == "" {
+		owner = "_"
+	}
+	return path.Join("hashes", owner, contentHash)
+}
+
 // Global metadata storage instance
 var MetadataManager MetadataStore
 
@@ -426,6 +659,11 @@ func InitMetadataStore(cfg *config.Config) error {
 			logger.Debug("Metadata migration to Redis already completed")
 		}
 
+		if err := EnsureRandomIndexes(context.Background()); err != nil {
+			logger.Warn("Failed to build random selection indexes",
+				zap.Error(err))
+		}
+
 		return nil
 	}
 
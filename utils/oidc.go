@@ -242,6 +242,7 @@ func CreateOrUpdateUser(ctx context.Context, userInfo *OIDCUserInfo, provider st
 		// Update existing user
 		user.CreatedAt = existingUser.CreatedAt
 		user.IsActive = existingUser.IsActive
+		user.Handle = existingUser.Handle
 		if err := UserManager.UpdateUser(ctx, user); err != nil {
 			return nil, fmt.Errorf("failed to update user: %v", err)
 		}
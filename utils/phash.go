@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"image/png"
+	"strconv"
+
+	"github.com/h2non/bimg"
+)
+
+// Size of the grayscale grid sampled for the difference hash. One extra column
+// is needed so every row yields eight left/right comparisons.
+const (
+	dHashWidth  = 9
+	dHashHeight = 8
+)
+
+// PerceptualHash computes a 64-bit difference hash (dHash) of the decoded image.
+// Resized or re-compressed copies of the same picture produce hashes that differ
+// in only a few bits. The hash is returned as 16 hex characters.
+func PerceptualHash(data []byte) (string, error) {
+	grid, err := GetWorkerPool().ProcessTask(func() ([]byte, error) {
+		return bimg.NewImage(data).Process(bimg.Options{
+			Width:          dHashWidth,
+			Height:         dHashHeight,
+			Force:          true,
+			Interpretation: bimg.InterpretationBW,
+			Type:           bimg.PNG,
+		})
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to downscale image for hashing: %v", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(grid))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode hash grid: %v", err)
+	}
+
+	bounds := img.Bounds()
+	if bounds.Dx() != dHashWidth || bounds.Dy() != dHashHeight {
+		return "", fmt.Errorf("unexpected hash grid size %dx%d", bounds.Dx(), bounds.Dy())
+	}
+
+	luma := func(x, y int) uint8 {
+		return color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y
+	}
+
+	var hash uint64
+	for y := 0; y < dHashHeight; y++ {
+		for x := 0; x < dHashWidth-1; x++ {
+			hash <<= 1
+			if luma(x, y) < luma(x+1, y) {
+				hash |= 1
+			}
+		}
+	}
+
+	return fmt.Sprintf("%016x", hash), nil
+}
+
+// ParsePerceptualHash decodes a perceptual hash into its 64 bits. The number of
+// differing bits between two hashes is bits.OnesCount64(a ^ b).
+func ParsePerceptualHash(hash string) (uint64, error) {
+	value, err := strconv.ParseUint(hash, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid perceptual hash: %s", hash)
+	}
+	return value, nil
+}
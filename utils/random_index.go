@@ -0,0 +1,322 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/Yuri-NagaSaki/ImageFlow/utils/logger"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// RandomOrientations lists the orientations that have a random selection index
+var RandomOrientations = []string{"landscape", "portrait", "square"}
+
+// randomIndexVersion is bumped whenever the layout of the random indexes changes,
+// so existing deployments rebuild them on startup
+const randomIndexVersion = "3"
+
+// RandomFilter describes the candidates of a random image request
+type RandomFilter struct {
+	Orientation string         // Required orientation, any orientation if empty
+	UserID      string         // Only images of this user, if set
+	Tags        []string       // Tags the image must all carry
+	ExcludeTags []string       // Tags the image must not carry
+	ImageIDs    []string       // Only these images (e.g. the members of a collection), if set
+	Ratio       RatioRange     // Aspect ratios the image must have, if set
+	Dimensions  DimensionRange // Pixel dimensions the image must have, if set
+}
+
+// randomOrientationKey is the set of listed images with an orientation
+func randomOrientationKey(orientation string) string {
+	return RedisPrefix + "random:orientation:" + orientation
+}
+
+// randomUserKey is the set of listed images of a user
+func randomUserKey(userID string) string {
+	return RedisPrefix + "random:user:" + userID
+}
+
+// randomAspectKey is the sorted set of listed images of known dimensions scored by aspect ratio
+func randomAspectKey() string {
+	return RedisPrefix + "random:aspect"
+}
+
+// randomIndexVersionKey records the version of the random indexes that has been built
+func randomIndexVersionKey() string {
+	return RedisPrefix + "random:index_version"
+}
+
+// indexForRandom queues the index updates that make an image eligible for random
+// selection when it is listed, and remove it from every random index otherwise
+func indexForRandom(ctx context.Context, pipe redis.Pipeliner, metadata *ImageMetadata) {
+	listed := metadata.IsListed()
+	for _, orientation := range RandomOrientations {
+		if listed && orientation == metadata.Orientation {
+			pipe.SAdd(ctx, randomOrientationKey(orientation), metadata.ID)
+		} else {
+			pipe.SRem(ctx, randomOrientationKey(orientation), metadata.ID)
+		}
+	}
+
+	if metadata.UserID != "" {
+		if listed {
+			pipe.SAdd(ctx, randomUserKey(metadata.UserID), metadata.ID)
+		} else {
+			pipe.SRem(ctx, randomUserKey(metadata.UserID), metadata.ID)
+		}
+	}
+
+	if ratio := metadata.Ratio(); listed && ratio > 0 {
+		pipe.ZAdd(ctx, randomAspectKey(), redis.Z{Score: ratio, Member: metadata.ID})
+	} else {
+		pipe.ZRem(ctx, randomAspectKey(), metadata.ID)
+	}
+}
+
+// unindexForRandom queues the removal of an image from every random index
+func unindexForRandom(ctx context.Context, pipe redis.Pipeliner, id string, userID string) {
+	for _, orientation := range RandomOrientations {
+		pipe.SRem(ctx, randomOrientationKey(orientation), id)
+	}
+	if userID != "" {
+		pipe.SRem(ctx, randomUserKey(userID), id)
+	}
+	pipe.ZRem(ctx, randomAspectKey(), id)
+	pipe.ZRem(ctx, randomWeightsKey(), id)
+	pipe.ZRem(ctx, randomServedKey(), id)
+}
+
+// EnsureRandomIndexes builds the random selection and dimension indexes from the
+// stored metadata unless the current version has already been built
+func EnsureRandomIndexes(ctx context.Context) error {
+	if !IsRedisMetadataStore() {
+		return fmt.Errorf("redis not enabled")
+	}
+
+	if version, err := RedisClient.Get(ctx, randomIndexVersionKey()).Result(); err == nil && version == randomIndexVersion {
+		return nil
+	} else if err != nil && err != redis.Nil {
+		return fmt.Errorf("failed to read random index version: %v", err)
+	}
+
+	store := NewRedisMetadataStore()
+	allMetadata, err := store.GetAllMetadata(ctx)
+	if err != nil {
+		return err
+	}
+
+	pipe := RedisClient.Pipeline()
+	for _, metadata := range allMetadata {
+		indexForRandom(ctx, pipe, metadata)
+		indexDimensions(ctx, pipe, metadata)
+		if weight := metadata.RandomWeight(); weight != 1 {
+			pipe.ZAdd(ctx, randomWeightsKey(), redis.Z{Score: weight, Member: metadata.ID})
+		}
+	}
+	pipe.Set(ctx, randomIndexVersionKey(), randomIndexVersion, 0)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to build random indexes: %v", err)
+	}
+
+	logger.Info("Built random selection indexes",
+		zap.Int("images", len(allMetadata)))
+	return nil
+}
+
+// candidateKeys returns the sets whose intersection holds the candidates of a filter,
+// apart from the orientation sets when any orientation is accepted
+func (f RandomFilter) candidateKeys() []string {
+	var keys []string
+	if f.Orientation != "" {
+		keys = append(keys, randomOrientationKey(f.Orientation))
+	}
+	if f.UserID != "" {
+		keys = append(keys, randomUserKey(f.UserID))
+	}
+	for _, tag := range f.Tags {
+		keys = append(keys, RedisPrefix+"tag:"+tag)
+	}
+	return keys
+}
+
+// RandomCandidateIDs returns the IDs of all listed images matching a filter. The
+// filtering happens inside Redis with set intersection and difference and a score
+// ranges on the aspect ratio and dimension indexes, so no per-image metadata is read.
+func RandomCandidateIDs(ctx context.Context, filter RandomFilter) ([]string, error) {
+	if !IsRedisMetadataStore() {
+		return nil, fmt.Errorf("redis not enabled")
+	}
+
+	ids, err := filter.setCandidateIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ids, err = filter.keepRatio(ctx, ids); err != nil {
+		return nil, err
+	}
+	if ids, err = FilterByDimensions(ctx, ids, filter.Dimensions); err != nil {
+		return nil, err
+	}
+	return filter.keepImageIDs(ids), nil
+}
+
+// setCandidateIDs returns the listed images passing the set-based parts of a filter
+func (f RandomFilter) setCandidateIDs(ctx context.Context) ([]string, error) {
+	keys := f.candidateKeys()
+	if f.Orientation != "" && len(f.ExcludeTags) == 0 {
+		ids, err := RedisClient.SInter(ctx, keys...).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to intersect random indexes: %v", err)
+		}
+		return ids, nil
+	}
+
+	// SDIFF only subtracts from a single set, so materialise the intersection first
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("failed to generate temporary key: %v", err)
+	}
+	tmpKey := RedisPrefix + "tmp:random:" + hex.EncodeToString(suffix)
+
+	diffKeys := []string{tmpKey}
+	for _, tag := range f.ExcludeTags {
+		diffKeys = append(diffKeys, RedisPrefix+"tag:"+tag)
+	}
+
+	pipe := RedisClient.TxPipeline()
+	if f.Orientation == "" {
+		// Any orientation: start from the union of the orientation sets
+		orientationKeys := make([]string, len(RandomOrientations))
+		for i, orientation := range RandomOrientations {
+			orientationKeys[i] = randomOrientationKey(orientation)
+		}
+		pipe.SUnionStore(ctx, tmpKey, orientationKeys...)
+		keys = append(keys, tmpKey)
+	}
+	pipe.SInterStore(ctx, tmpKey, keys...)
+	pipe.Expire(ctx, tmpKey, time.Minute)
+	diff := pipe.SDiff(ctx, diffKeys...)
+	pipe.Del(ctx, tmpKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to filter random indexes: %v", err)
+	}
+	return diff.Val(), nil
+}
+
+// keepRatio drops the IDs whose aspect ratio lies outside the range of a filter
+func (f RandomFilter) keepRatio(ctx context.Context, ids []string) ([]string, error) {
+	if !f.Ratio.IsSet() || len(ids) == 0 {
+		return ids, nil
+	}
+
+	lower, upper := f.Ratio.scoreBounds()
+	inRange, err := RedisClient.ZRangeByScore(ctx, randomAspectKey(), &redis.ZRangeBy{
+		Min: lower,
+		Max: upper,
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read aspect ratio index: %v", err)
+	}
+
+	allowed := make(map[string]bool, len(inRange))
+	for _, id := range inRange {
+		allowed[id] = true
+	}
+	kept := ids[:0]
+	for _, id := range ids {
+		if allowed[id] {
+			kept = append(kept, id)
+		}
+	}
+	return kept, nil
+}
+
+// keepImageIDs drops the IDs outside the image restriction of a filter
+func (f RandomFilter) keepImageIDs(ids []string) []string {
+	if f.ImageIDs == nil {
+		return ids
+	}
+
+	allowed := make(map[string]bool, len(f.ImageIDs))
+	for _, id := range f.ImageIDs {
+		allowed[id] = true
+	}
+	kept := ids[:0]
+	for _, id := range ids {
+		if allowed[id] {
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
+
+// maxSampledWeights caps the custom-weight images a weighted sample reads. Beyond it
+// sampling is refused and selection goes through the full candidate list.
+const maxSampledWeights = 1000
+
+// RandomSample is a draw from the random index of an orientation for weighted
+// selection without reading the whole index: a uniform sample of the candidates of
+// the default weight plus every candidate with a custom weight
+type RandomSample struct {
+	Defaults     []string           // Distinct candidates of the default weight 1, drawn uniformly
+	DefaultCount int64              // Number of candidates of the default weight
+	Weighted     map[string]float64 // Candidates with a custom weight
+}
+
+// SampleRandomIDs samples the listed images of an orientation with SRANDMEMBER, enough
+// to select up to count of them in proportion to their weights. It suits requests
+// without tag filters, seeds or biases, where the candidate list itself is not needed.
+func SampleRandomIDs(ctx context.Context, filter RandomFilter, count int) (*RandomSample, error) {
+	if !IsRedisMetadataStore() {
+		return nil, fmt.Errorf("redis not enabled")
+	}
+	if filter.Orientation == "" || len(filter.Tags) > 0 || len(filter.ExcludeTags) > 0 || filter.UserID != "" ||
+		filter.ImageIDs != nil || filter.Ratio.IsSet() || filter.Dimensions.IsSet() {
+		return nil, fmt.Errorf("sampling only supports orientation filters")
+	}
+
+	weights, err := RedisClient.ZRangeWithScores(ctx, randomWeightsKey(), 0, maxSampledWeights).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read random weights: %v", err)
+	}
+	if len(weights) > maxSampledWeights {
+		return nil, fmt.Errorf("too many custom random weights to sample")
+	}
+
+	// Oversample so that enough default-weight images remain once weighted ones are dropped
+	key := randomOrientationKey(filter.Orientation)
+	pipe := RedisClient.Pipeline()
+	card := pipe.SCard(ctx, key)
+	sampled := pipe.SRandMemberN(ctx, key, int64(count+len(weights)))
+	var members *redis.BoolSliceCmd
+	if len(weights) > 0 {
+		ids := make([]interface{}, len(weights))
+		for i, weight := range weights {
+			ids[i] = weight.Member
+		}
+		members = pipe.SMIsMember(ctx, key, ids...)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to sample random index: %v", err)
+	}
+
+	sample := &RandomSample{Weighted: make(map[string]float64)}
+	if members != nil {
+		for i, isMember := range members.Val() {
+			if isMember {
+				sample.Weighted[weights[i].Member.(string)] = weights[i].Score
+			}
+		}
+	}
+	sample.DefaultCount = card.Val() - int64(len(sample.Weighted))
+	for _, id := range sampled.Val() {
+		if _, ok := sample.Weighted[id]; !ok && len(sample.Defaults) < count {
+			sample.Defaults = append(sample.Defaults, id)
+		}
+	}
+	return sample, nil
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Yuri-NagaSaki/ImageFlow/utils/logger"
+	"go.uber.org/zap"
+)
+
+// RandomStats holds the per-image inputs of weighted random selection
+type RandomStats struct {
+	Weight     float64   // Relative weight, 1 unless customised
+	UploadTime time.Time // Upload time, zero if unknown
+	Served     int64     // Number of times the image was served by the random API
+}
+
+// randomWeightsKey is the sorted set of images with a custom weight, scored by weight
+func randomWeightsKey() string {
+	return RedisPrefix + "random:weights"
+}
+
+// randomServedKey is the sorted set of images scored by how often the random API served them
+func randomServedKey() string {
+	return RedisPrefix + "random:served"
+}
+
+// GetRandomStats returns the selection weight, upload time and serve count of each
+// image, in the order of ids. All values come from sorted set indexes, so the
+// lookup costs one round trip regardless of the number of candidates.
+func GetRandomStats(ctx context.Context, ids []string) ([]RandomStats, error) {
+	if !IsRedisMetadataStore() {
+		return nil, fmt.Errorf("redis not enabled")
+	}
+
+	pipe := RedisClient.Pipeline()
+	weights := pipe.ZMScore(ctx, randomWeightsKey(), ids...)
+	uploads := pipe.ZMScore(ctx, RedisPrefix+"images", ids...)
+	served := pipe.ZMScore(ctx, randomServedKey(), ids...)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to read random selection stats: %v", err)
+	}
+
+	stats := make([]RandomStats, len(ids))
+	for i := range ids {
+		// Members missing from an index are reported as 0
+		stats[i].Weight = weights.Val()[i]
+		if stats[i].Weight <= 0 {
+			stats[i].Weight = 1
+		}
+		if uploaded := uploads.Val()[i]; uploaded > 0 {
+			stats[i].UploadTime = time.Unix(int64(uploaded), 0)
+		}
+		stats[i].Served = int64(served.Val()[i])
+	}
+	return stats, nil
+}
+
+// RecordRandomServes increments the serve counts of images returned by the random API
+func RecordRandomServes(ctx context.Context, ids []string) {
+	if !IsRedisMetadataStore() || len(ids) == 0 {
+		return
+	}
+
+	pipe := RedisClient.Pipeline()
+	for _, id := range ids {
+		pipe.ZIncrBy(ctx, randomServedKey(), 1, id)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.Warn("Failed to record random serves",
+			zap.Strings("ids", ids),
+			zap.Error(err))
+	}
+}
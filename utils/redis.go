@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -37,23 +38,32 @@ func IsRedisMetadataStore() bool {
 
 // ImageInfo represents information about an image
 type ImageInfo struct {
-	ID          string            `json:"id"`          // Filename without extension
-	FileName    string            `json:"filename"`    // Full filename with extension
-	URL         string            `json:"url"`         // URL to access the image
-	URLs        map[string]string `json:"urls"`        // URLs for all available formats
-	Orientation string            `json:"orientation"` // landscape or portrait
-	Format      string            `json:"format"`      // original, webp, avif
-	Size        int64             `json:"size"`        // File size in bytes
-	Path        string            `json:"path"`        // Path relative to storage root
-	StorageType string            `json:"storageType"` // "local" or "s3"
-	Tags        []string          `json:"tags"`        // Image tags for categorization
+	ID          string            `json:"id"`             // Filename without extension
+	FileName    string            `json:"filename"`       // Full filename with extension
+	URL         string            `json:"url"`            // URL to access the image
+	URLs        map[string]string `json:"urls"`           // URLs for all available formats
+	Orientation string            `json:"orientation"`    // landscape, portrait or square
+	Width       int               `json:"width"`          // Width of the original in pixels, 0 if unknown
+	Height      int               `json:"height"`         // Height of the original in pixels, 0 if unknown
+	AspectRatio float64           `json:"aspectRatio"`    // Width divided by height, 0 if unknown
+	EXIF        *EXIFData         `json:"exif,omitempty"` // Camera data parsed from the original
+	Format      string            `json:"format"`         // original, webp, avif
+	Size        int64             `json:"size"`           // File size in bytes
+	Path        string            `json:"path"`           // Path relative to storage root
+	StorageType string            `json:"storageType"`    // "local" or "s3"
+	Tags        []string          `json:"tags"`           // Image tags for categorization
+	Visibility  string            `json:"visibility"`     // public, unlisted or private
 }
 
 // CachedPageKey represents a unique key for cached page results
 type CachedPageKey struct {
+	UserID      string `json:"user_id"`
 	Orientation string `json:"orientation"`
 	Format      string `json:"format"`
 	Tag         string `json:"tag"`
+	Collection  string `json:"collection"`
+	Ratio       string `json:"ratio"`
+	Dimensions  string `json:"dimensions"`
 	Page        int    `json:"page"`
 	Limit       int    `json:"limit"`
 }
@@ -66,7 +76,7 @@ type PageCache struct {
 
 // String returns a string representation of CachedPageKey
 func (k CachedPageKey) String() string {
-	return fmt.Sprintf("%s:%s:%s:%d:%d", k.Orientation, k.Format, k.Tag, k.Page, k.Limit)
+	return fmt.Sprintf("%s:%s:%s:%s:%s:%s:%s:%d:%d", k.UserID, k.Orientation, k.Format, k.Tag, k.Collection, k.Ratio, k.Dimensions, k.Page, k.Limit)
 }
 
 // getCachedPage retrieves cached page data if available
@@ -78,7 +88,7 @@ func getCachedPage(ctx context.Context, key CachedPageKey) (*PageCache, error) {
 		return nil, fmt.Errorf("redis not enabled")
 	}
 
-	cacheKey := RedisPrefix + "page_cache:" + key.String()
+	cacheKey := pageCacheKey(ctx, key)
 	data, err := RedisClient.Get(ctx, cacheKey).Bytes()
 	if err == nil {
 		var cache PageCache
@@ -110,26 +120,28 @@ func setCachedPage(ctx context.Context, key CachedPageKey, data []ImageInfo) err
 		return err
 	}
 
-	cacheKey := RedisPrefix + "page_cache:" + key.String()
+	cacheKey := pageCacheKey(ctx, key)
 	return RedisClient.Set(ctx, cacheKey, cacheData, PageCacheExpiration).Err()
 }
 
-// ClearPageCache clears all page cache entries
+// pageCacheKey returns the Redis key of a cached page within the current cache generation
+func pageCacheKey(ctx context.Context, key CachedPageKey) string {
+	generation, err := RedisClient.Get(ctx, RedisPrefix+"page_cache_generation").Result()
+	if err != nil {
+		generation = "0"
+	}
+	return RedisPrefix + "page_cache:" + generation + ":" + key.String()
+}
+
+// ClearPageCache invalidates all page cache entries. Entries are versioned by a
+// generation counter, so this is a single INCR instead of a key scan; entries of
+// older generations simply expire.
 func ClearPageCache(ctx context.Context) error {
 	if !IsRedisMetadataStore() {
 		return nil // Redis is not enabled, no need to clear cache
 	}
 
-	pattern := RedisPrefix + "page_cache:*"
-	keys, err := RedisClient.Keys(ctx, pattern).Result()
-	if err != nil {
-		return err
-	}
-
-	if len(keys) > 0 {
-		return RedisClient.Del(ctx, keys...).Err()
-	}
-	return nil
+	return RedisClient.Incr(ctx, RedisPrefix+"page_cache_generation").Err()
 }
 
 // InitRedisClient initializes the Redis client
@@ -215,19 +227,58 @@ func (rms *RedisMetadataStore) SaveMetadata(ctx context.Context, metadata *Image
 		return fmt.Errorf("failed to marshal sizes: %v", err)
 	}
 
-	// Store metadata in hash
+	// Convert variants to JSON string
+	variantsJSON, err := json.Marshal(metadata.Variants)
+	if err != nil {
+		return fmt.Errorf("failed to marshal variants: %v", err)
+	}
+
+	// Convert EXIF data to JSON string, empty when the image has none
+	var exifJSON []byte
+	if metadata.EXIF != nil {
+		if exifJSON, err = json.Marshal(metadata.EXIF); err != nil {
+			return fmt.Errorf("failed to marshal EXIF data: %v", err)
+		}
+	}
+
+	// Find tags the image no longer carries so their indexes can be cleaned up
 	key := rms.prefix + metadata.ID
+	var staleTags []string
+	if oldTags, err := RedisClient.HGet(ctx, key, "tags").Result(); err == nil && oldTags != "" {
+		current := make(map[string]bool, len(metadata.Tags))
+		for _, tag := range metadata.Tags {
+			current[tag] = true
+		}
+		for _, tag := range strings.Split(oldTags, ",") {
+			if !current[tag] {
+				staleTags = append(staleTags, tag)
+			}
+		}
+	} else if err != nil && err != redis.Nil {
+		return fmt.Errorf("failed to read existing tags: %v", err)
+	}
+
+	// Store metadata in hash
 	pipe.HSet(ctx, key, map[string]interface{}{
-		"id":           metadata.ID,
-		"userID":       metadata.UserID,
-		"originalName": metadata.OriginalName,
-		"uploadTime":   metadata.UploadTime.Format(time.RFC3339),
-		"expiryTime":   metadata.ExpiryTime.Format(time.RFC3339),
-		"format":       metadata.Format,
-		"orientation":  metadata.Orientation,
-		"tags":         strings.Join(metadata.Tags, ","),
-		"paths":        string(pathsJSON),
-		"sizes":        string(sizesJSON),
+		"id":             metadata.ID,
+		"userID":         metadata.UserID,
+		"originalName":   metadata.OriginalName,
+		"uploadTime":     metadata.UploadTime.Format(time.RFC3339),
+		"expiryTime":     metadata.ExpiryTime.Format(time.RFC3339),
+		"format":         metadata.Format,
+		"orientation":    metadata.Orientation,
+		"width":          strconv.Itoa(metadata.Width),
+		"height":         strconv.Itoa(metadata.Height),
+		"aspectRatio":    strconv.FormatFloat(metadata.AspectRatio, 'f', -1, 64),
+		"exif":           string(exifJSON),
+		"tags":           strings.Join(metadata.Tags, ","),
+		"visibility":     metadata.Visibility,
+		"contentHash":    metadata.ContentHash,
+		"perceptualHash": metadata.PerceptualHash,
+		"weight":         strconv.FormatFloat(metadata.Weight, 'f', -1, 64),
+		"paths":          string(pathsJSON),
+		"sizes":          string(sizesJSON),
+		"variants":       string(variantsJSON),
 	})
 
 	// Add to user-specific image index
@@ -239,19 +290,47 @@ func (rms *RedisMetadataStore) SaveMetadata(ctx context.Context, metadata *Image
 		})
 	}
 
+	// Index content hash for per-user deduplication
+	if metadata.ContentHash != "" {
+		pipe.Set(ctx, contentHashKey(metadata.UserID, metadata.ContentHash), metadata.ID, 0)
+	}
+
 	// Add to sorted set for pagination
 	pipe.ZAdd(ctx, RedisPrefix+"images", redis.Z{
 		Score:  float64(metadata.UploadTime.Unix()),
 		Member: metadata.ID,
 	})
 
-	// Add to expiry index if expiry time is set
+	// Add to expiry index if expiry time is set, otherwise make sure it is not scheduled
+	expiryKey := RedisPrefix + "expiry"
 	if !metadata.ExpiryTime.IsZero() {
-		expiryKey := RedisPrefix + "expiry"
 		pipe.ZAdd(ctx, expiryKey, redis.Z{
 			Score:  float64(metadata.ExpiryTime.Unix()),
 			Member: metadata.ID,
 		})
+	} else {
+		pipe.ZRem(ctx, expiryKey, metadata.ID)
+	}
+
+	// Keep the random selection indexes in step with orientation and visibility
+	indexForRandom(ctx, pipe, metadata)
+
+	// Index dimensions for size filters
+	indexDimensions(ctx, pipe, metadata)
+
+	// Only images with a custom weight are kept in the weight index
+	if weight := metadata.RandomWeight(); weight != 1 {
+		pipe.ZAdd(ctx, randomWeightsKey(), redis.Z{
+			Score:  weight,
+			Member: metadata.ID,
+		})
+	} else {
+		pipe.ZRem(ctx, randomWeightsKey(), metadata.ID)
+	}
+
+	// Remove stale tag memberships
+	for _, tag := range staleTags {
+		pipe.SRem(ctx, RedisPrefix+"tag:"+tag, metadata.ID)
 	}
 
 	// Add tags
@@ -275,6 +354,8 @@ func (rms *RedisMetadataStore) SaveMetadata(ctx context.Context, metadata *Image
 		return fmt.Errorf("failed to save metadata to Redis: %v", err)
 	}
 
+	pruneUnusedTags(ctx, staleTags)
+
 	// Clear page cache when new data is added
 	if err := ClearPageCache(ctx); err != nil {
 		logger.Warn("Failed to clear page cache", zap.Error(err))
@@ -286,6 +367,51 @@ func (rms *RedisMetadataStore) SaveMetadata(ctx context.Context, metadata *Image
 	return nil
 }
 
+// UpdateVariants changes the cached variant records of an image in place. Only the
+// variants field is written, and only while the image still exists, so concurrent
+// updates of other fields and deletions are never overwritten.
+func (rms *RedisMetadataStore) UpdateVariants(ctx context.Context, id string, update func(map[string]int64)) error {
+	if !IsRedisMetadataStore() {
+		return fmt.Errorf("redis not enabled")
+	}
+
+	key := rms.prefix + id
+	txf := func(tx *redis.Tx) error {
+		values, err := tx.HMGet(ctx, key, "id", "variants").Result()
+		if err != nil {
+			return err
+		}
+		if values[0] == nil {
+			return fmt.Errorf("metadata not found: %s", id)
+		}
+
+		variants := make(map[string]int64)
+		if raw, ok := values[1].(string); ok && raw != "" && raw != "null" {
+			json.Unmarshal([]byte(raw), &variants)
+		}
+		update(variants)
+
+		variantsJSON, err := json.Marshal(variants)
+		if err != nil {
+			return fmt.Errorf("failed to marshal variants: %v", err)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, "variants", string(variantsJSON))
+			return nil
+		})
+		return err
+	}
+
+	// Retry when the image was modified between reading and writing the field
+	for attempt := 0; attempt < 3; attempt++ {
+		err := RedisClient.Watch(ctx, txf, key)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return fmt.Errorf("failed to update variants of %s: concurrent modification", id)
+}
+
 // GetMetadata retrieves image metadata from Redis with optimized structure
 func (rms *RedisMetadataStore) GetMetadata(ctx context.Context, id string) (*ImageMetadata, error) {
 	if !IsRedisMetadataStore() {
@@ -302,11 +428,24 @@ func (rms *RedisMetadataStore) GetMetadata(ctx context.Context, id string) (*Ima
 	}
 
 	metadata := &ImageMetadata{
-		ID:           data["id"],
-		UserID:       data["userID"],
-		OriginalName: data["originalName"],
-		Format:       data["format"],
-		Orientation:  data["orientation"],
+		ID:             data["id"],
+		UserID:         data["userID"],
+		OriginalName:   data["originalName"],
+		Format:         data["format"],
+		Orientation:    data["orientation"],
+		Visibility:     data["visibility"],
+		ContentHash:    data["contentHash"],
+		PerceptualHash: data["perceptualHash"],
+	}
+
+	if weight, err := strconv.ParseFloat(data["weight"], 64); err == nil {
+		metadata.Weight = weight
+	}
+	metadata.Width, _ = strconv.Atoi(data["width"])
+	metadata.Height, _ = strconv.Atoi(data["height"])
+	metadata.AspectRatio, _ = strconv.ParseFloat(data["aspectRatio"], 64)
+	if exif := data["exif"]; exif != "" {
+		json.Unmarshal([]byte(exif), &metadata.EXIF)
 	}
 
 	// Parse times
@@ -335,6 +474,11 @@ func (rms *RedisMetadataStore) GetMetadata(ctx context.Context, id string) (*Ima
 		json.Unmarshal([]byte(sizes), &metadata.Sizes)
 	}
 
+	// Parse cached variants
+	if variants := data["variants"]; variants != "" && variants != "null" {
+		json.Unmarshal([]byte(variants), &metadata.Variants)
+	}
+
 	return metadata, nil
 }
 
@@ -392,6 +536,31 @@ func (rms *RedisMetadataStore) DeleteMetadata(ctx context.Context, id string) er
 		}
 	}
 
+	pruneUnusedTags(ctx, metadata.Tags)
+
+	// Remove from user-specific image index
+	if metadata.UserID != "" {
+		userImagesKey := RedisPrefix + "user:" + metadata.UserID + ":images"
+		if err := RedisClient.ZRem(ctx, userImagesKey, id).Err(); err != nil {
+			logger.Warn("Failed to remove from user images index",
+				zap.String("user_id", metadata.UserID),
+				zap.String("id", id),
+				zap.Error(err))
+		}
+	}
+
+	// Remove from content hash index unless it already points at another image
+	if metadata.ContentHash != "" {
+		hashKey := contentHashKey(metadata.UserID, metadata.ContentHash)
+		if indexedID, err := RedisClient.Get(ctx, hashKey).Result(); err == nil && indexedID == id {
+			if err := RedisClient.Del(ctx, hashKey).Err(); err != nil {
+				logger.Warn("Failed to remove from content hash index",
+					zap.String("id", id),
+					zap.Error(err))
+			}
+		}
+	}
+
 	// Remove from expiry index
 	expiryKey := RedisPrefix + "expiry"
 	if err := RedisClient.ZRem(ctx, expiryKey, id).Err(); err != nil {
@@ -400,6 +569,16 @@ func (rms *RedisMetadataStore) DeleteMetadata(ctx context.Context, id string) er
 			zap.Error(err))
 	}
 
+	// Remove from random selection and dimension indexes
+	pipe := RedisClient.Pipeline()
+	unindexForRandom(ctx, pipe, id, metadata.UserID)
+	unindexDimensions(ctx, pipe, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.Warn("Failed to remove from random indexes",
+			zap.String("id", id),
+			zap.Error(err))
+	}
+
 	// Remove from main images index
 	imagesKey := RedisPrefix + "images"
 	if err := RedisClient.ZRem(ctx, imagesKey, id).Err(); err != nil {
@@ -419,6 +598,27 @@ func (rms *RedisMetadataStore) DeleteMetadata(ctx context.Context, id string) er
 	return nil
 }
 
+// pruneUnusedTags removes tags from the all_tags set once no image carries them anymore
+func pruneUnusedTags(ctx context.Context, tags []string) {
+	allTagsKey := RedisPrefix + "all_tags"
+	for _, tag := range tags {
+		count, err := RedisClient.SCard(ctx, RedisPrefix+"tag:"+tag).Result()
+		if err != nil {
+			logger.Warn("Failed to count tag members",
+				zap.String("tag", tag),
+				zap.Error(err))
+			continue
+		}
+		if count == 0 {
+			if err := RedisClient.SRem(ctx, allTagsKey, tag).Err(); err != nil {
+				logger.Warn("Failed to remove unused tag",
+					zap.String("tag", tag),
+					zap.Error(err))
+			}
+		}
+	}
+}
+
 // GetAllUniqueTags retrieves all unique tags from Redis
 func GetAllUniqueTags(ctx context.Context) ([]string, error) {
 	if !IsRedisMetadataStore() {
@@ -674,6 +874,35 @@ func (rms *RedisMetadataStore) GetUserMetadata(ctx context.Context, userID strin
 	return userMetadata, nil
 }
 
+// contentHashKey returns the Redis key mapping a user's content hash to an image ID
+func contentHashKey(userID, contentHash string) string {
+	return RedisPrefix + "hash:" + userID + ":" + contentHash
+}
+
+// FindByContentHash finds a user's image by content hash using the hash index
+func (rms *RedisMetadataStore) FindByContentHash(ctx context.Context, userID, contentHash string) (*ImageMetadata, error) {
+	if !IsRedisMetadataStore() {
+		return nil, fmt.Errorf("redis not enabled")
+	}
+
+	hashKey := contentHashKey(userID, contentHash)
+	id, err := RedisClient.Get(ctx, hashKey).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up content hash: %v", err)
+	}
+
+	metadata, err := rms.GetMetadata(ctx, id)
+	if err != nil || metadata.ContentHash != contentHash {
+		// Stale index entry, e.g. metadata removed outside DeleteMetadata
+		RedisClient.Del(ctx, hashKey)
+		return nil, nil
+	}
+	return metadata, nil
+}
+
 // VerifyImageOwnership verifies that a user owns an image in Redis
 func (rms *RedisMetadataStore) VerifyImageOwnership(ctx context.Context, imageID, userID string) error {
 	if !IsRedisMetadataStore() {
@@ -0,0 +1,210 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Yuri-NagaSaki/ImageFlow/config"
+)
+
+// maxRemoteRedirects caps the redirects followed when downloading a remote image
+const maxRemoteRedirects = 5
+
+// blockedPrefixes are address ranges that are not reachable on the public internet
+// and that net.IP's classification helpers do not cover
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "This" network
+	netip.MustParsePrefix("100.64.0.0/10"), // Carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),  // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"), // Benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),   // Reserved
+	netip.MustParsePrefix("64:ff9b::/96"),  // NAT64, may map to private IPv4 addresses
+}
+
+// IPv6 transition ranges that embed an IPv4 address the traffic is relayed to
+var (
+	sixToFourPrefix = netip.MustParsePrefix("2002::/16") // 6to4, IPv4 in bytes 2-5
+	teredoPrefix    = netip.MustParsePrefix("2001::/32") // Teredo, server IPv4 in bytes 4-7, client IPv4 inverted in bytes 12-15
+)
+
+// RemoteFetcher downloads images from user supplied URLs while refusing to
+// connect to private, loopback and link-local addresses unless allowlisted
+type RemoteFetcher struct {
+	client   *http.Client
+	maxBytes int64
+	hosts    map[string]bool
+	prefixes []netip.Prefix
+}
+
+// NewRemoteFetcher creates a fetcher using the remote upload limits and allowlist of the configuration
+func NewRemoteFetcher(cfg *config.Config) *RemoteFetcher {
+	f := &RemoteFetcher{
+		maxBytes: int64(cfg.RemoteUploadMaxMB) << 20,
+		hosts:    make(map[string]bool),
+	}
+
+	for _, entry := range cfg.RemoteUploadAllowlist {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			f.prefixes = append(f.prefixes, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			f.prefixes = append(f.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+		} else if entry != "" {
+			f.hosts[entry] = true
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	transport := &http.Transport{
+		// Environment proxies would bypass the address check, so never use one
+		Proxy: nil,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			guarded := *dialer
+			if !f.hosts[strings.ToLower(host)] {
+				// Check the address actually being connected to, after DNS
+				// resolution, so rebinding tricks cannot reach internal hosts
+				guarded.Control = f.checkAddress
+			}
+			return guarded.DialContext(ctx, network, addr)
+		},
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 15 * time.Second,
+		MaxIdleConnsPerHost:   2,
+	}
+
+	f.client = &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(cfg.RemoteUploadTimeout) * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRemoteRedirects {
+				return fmt.Errorf("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme: %s", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+
+	return f
+}
+
+// checkAddress is a dialer control function rejecting addresses that are not publicly routable
+func (f *RemoteFetcher) checkAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("invalid address: %s", host)
+	}
+	addr = addr.Unmap()
+
+	for _, prefix := range f.prefixes {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+
+	if !isPublicAddress(addr) {
+		return fmt.Errorf("connecting to non-public address %s is not allowed", addr)
+	}
+	return nil
+}
+
+// isPublicAddress reports whether an address is routable on the public internet
+func isPublicAddress(addr netip.Addr) bool {
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	// Transition addresses are only as public as the IPv4 addresses they embed
+	b := addr.As16()
+	switch {
+	case sixToFourPrefix.Contains(addr):
+		return isPublicAddress(netip.AddrFrom4([4]byte{b[2], b[3], b[4], b[5]}))
+	case teredoPrefix.Contains(addr):
+		client := netip.AddrFrom4([4]byte{b[12] ^ 0xff, b[13] ^ 0xff, b[14] ^ 0xff, b[15] ^ 0xff})
+		return isPublicAddress(netip.AddrFrom4([4]byte{b[4], b[5], b[6], b[7]})) && isPublicAddress(client)
+	}
+	return true
+}
+
+// Fetch downloads an image, enforcing the size limit and requiring an image content type.
+// It returns the image bytes and a file name derived from the URL.
+func (f *RemoteFetcher) Fetch(ctx context.Context, rawURL string) ([]byte, string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || u.Host == "" {
+		return nil, "", fmt.Errorf("invalid URL")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, "", fmt.Errorf("only http and https URLs are supported")
+	}
+	if u.User != nil {
+		return nil, "", fmt.Errorf("URLs with credentials are not supported")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid URL")
+	}
+	req.Header.Set("Accept", "image/*")
+	req.Header.Set("User-Agent", "ImageFlow/remote-upload")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("download failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("remote server returned %s", resp.Status)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "image/") {
+		return nil, "", fmt.Errorf("unsupported content type: %s", resp.Header.Get("Content-Type"))
+	}
+
+	if resp.ContentLength > f.maxBytes {
+		return nil, "", fmt.Errorf("image exceeds the %d MB limit", f.maxBytes>>20)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, f.maxBytes+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("download failed: %v", err)
+	}
+	if int64(len(data)) > f.maxBytes {
+		return nil, "", fmt.Errorf("image exceeds the %d MB limit", f.maxBytes>>20)
+	}
+
+	// Name the image after the last path segment of the final URL
+	filename := path.Base(resp.Request.URL.Path)
+	if filename == "" || filename == "." || filename == "/" {
+		filename = resp.Request.URL.Hostname()
+	}
+
+	return data, filename, nil
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Yuri-NagaSaki/ImageFlow/config"
+)
+
+// SignedURLPath is the route prefix that serves signed image URLs
+const SignedURLPath = "/api/signed/"
+
+// signURLPayload computes the HMAC-SHA256 signature of an image ID, variant and expiry
+func signURLPayload(key, id, variant string, exp int64) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	fmt.Fprintf(mac, "%s|%s|%d", id, variant, exp)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignImageURL returns a signed, expiring URL path for a stored rendition of an image
+func SignImageURL(cfg *config.Config, id, variant string, expiresAt time.Time) (string, error) {
+	if cfg.URLSigningKey == "" {
+		return "", fmt.Errorf("URL signing key not configured")
+	}
+	if variant == "" {
+		variant = "original"
+	}
+
+	exp := expiresAt.Unix()
+	query := url.Values{}
+	query.Set("variant", variant)
+	query.Set("exp", strconv.FormatInt(exp, 10))
+	query.Set("sig", signURLPayload(cfg.URLSigningKey, id, variant, exp))
+
+	return SignedURLPath + url.PathEscape(id) + "?" + query.Encode(), nil
+}
+
+// VerifyImageSignature checks that a signed URL is authentic and has not expired
+func VerifyImageSignature(cfg *config.Config, id, variant, expParam, sig string) error {
+	if cfg.URLSigningKey == "" {
+		return fmt.Errorf("URL signing key not configured")
+	}
+	if expParam == "" || sig == "" {
+		return fmt.Errorf("missing exp or sig parameter")
+	}
+
+	exp, err := strconv.ParseInt(expParam, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid exp parameter: %s", expParam)
+	}
+
+	expected := signURLPayload(cfg.URLSigningKey, id, variant, exp)
+	if !hmac.Equal([]byte(expected), []byte(sig)) {
+		return fmt.Errorf("invalid signature")
+	}
+	if time.Now().Unix() > exp {
+		return fmt.Errorf("signed URL expired")
+	}
+
+	return nil
+}
@@ -5,9 +5,12 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/fs"
+	"mime"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/Yuri-NagaSaki/ImageFlow/config"
 	"github.com/Yuri-NagaSaki/ImageFlow/utils/logger"
@@ -17,17 +20,53 @@ import (
 	"go.uber.org/zap"
 )
 
+// S3 multipart upload sizes. S3 requires every part except the last to be at least 5 MB.
+const (
+	s3MultipartThreshold = 16 << 20
+	s3PartSize           = 8 << 20
+)
+
 // S3Object represents an object in S3 storage
 type S3Object struct {
 	Key  string
 	Size int64
 }
 
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key          string    // Storage key
+	Size         int64     // Size in bytes
+	ContentType  string    // MIME type of the object
+	LastModified time.Time // Time of the last write
+	ETag         string    // Entity tag reported by the backend (empty for local files)
+}
+
 // StorageProvider defines the interface for storage operations
 type StorageProvider interface {
 	Store(ctx context.Context, key string, data []byte) error
 	Get(ctx context.Context, key string) ([]byte, error)
 	Delete(ctx context.Context, key string) error
+
+	// Put stores an object read from r. size may be -1 if it is unknown;
+	// an empty contentType is derived from the key.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Open returns a reader for an object, which the caller must close
+	Open(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	// OpenRange returns a reader for length bytes of an object starting at offset;
+	// a negative length reads to the end of the object
+	OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// Stat returns information about an object without reading it
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// List returns all objects whose key starts with prefix
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+}
+
+// ContentTypeForKey derives the MIME type of an object from the extension of its key
+func ContentTypeForKey(key string) string {
+	if contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(key))); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
 }
 
 // LocalStorage implements StorageProvider for local filesystem
@@ -39,10 +78,16 @@ func NewLocalStorage(basePath string) (*LocalStorage, error) {
 	dirs := []string{
 		filepath.Join(basePath, "original", "landscape"),
 		filepath.Join(basePath, "original", "portrait"),
+		filepath.Join(basePath, "original", "square"),
 		filepath.Join(basePath, "landscape", "webp"),
 		filepath.Join(basePath, "landscape", "avif"),
 		filepath.Join(basePath, "portrait", "webp"),
 		filepath.Join(basePath, "portrait", "avif"),
+		filepath.Join(basePath, "square", "webp"),
+		filepath.Join(basePath, "square", "avif"),
+		filepath.Join(basePath, "landscape", "thumb"),
+		filepath.Join(basePath, "portrait", "thumb"),
+		filepath.Join(basePath, "square", "thumb"),
 	}
 
 	for _, dir := range dirs {
@@ -86,6 +131,152 @@ func (ls *LocalStorage) Delete(ctx context.Context, key string) error {
 	return os.Remove(filepath.Join(ls.BasePath, key))
 }
 
+// Put writes an object to a temporary file and renames it into place, so
+// readers never see a partially written file
+func (ls *LocalStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	fullPath := filepath.Join(ls.BasePath, key)
+	dir := filepath.Dir(fullPath)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logger.Error("Failed to create directory",
+			zap.String("dir", dir),
+			zap.Error(err))
+		return fmt.Errorf("failed to create directory %s: %v", dir, err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file in %s: %v", dir, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		logger.Error("Failed to write file",
+			zap.String("path", fullPath),
+			zap.Error(err))
+		return fmt.Errorf("failed to write file %s: %v", fullPath, err)
+	}
+	if size >= 0 && written != size {
+		return fmt.Errorf("short write for %s: wrote %d of %d bytes", fullPath, written, size)
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to set permissions on %s: %v", fullPath, err)
+	}
+	if err := os.Rename(tmp.Name(), fullPath); err != nil {
+		return fmt.Errorf("failed to move file into place %s: %v", fullPath, err)
+	}
+
+	logger.Info("File stored locally",
+		zap.String("key", key),
+		zap.String("path", fullPath),
+		zap.Int64("size", written))
+	return nil
+}
+
+// Open opens a local file for reading
+func (ls *LocalStorage) Open(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	file, err := os.Open(filepath.Join(ls.BasePath, key))
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, ObjectInfo{}, err
+	}
+	if stat.IsDir() {
+		file.Close()
+		return nil, ObjectInfo{}, fmt.Errorf("%s is a directory", key)
+	}
+
+	return file, ls.objectInfo(key, stat), nil
+}
+
+// OpenRange opens a local file positioned at offset
+func (ls *LocalStorage) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.Join(ls.BasePath, key))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return file, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, length), file}, nil
+}
+
+// Stat returns information about a local file
+func (ls *LocalStorage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	stat, err := os.Stat(filepath.Join(ls.BasePath, key))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if stat.IsDir() {
+		return ObjectInfo{}, fmt.Errorf("%s is a directory", key)
+	}
+	return ls.objectInfo(key, stat), nil
+}
+
+// List walks the directory containing prefix and returns the files whose key starts with it
+func (ls *LocalStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	root := filepath.Join(ls.BasePath, filepath.FromSlash(prefix[:strings.LastIndex(prefix, "/")+1]))
+
+	var objects []ObjectInfo
+	err := filepath.WalkDir(root, func(fullPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && fullPath == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".tmp-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(ls.BasePath, fullPath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		stat, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		objects = append(objects, ls.objectInfo(key, stat))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %v", prefix, err)
+	}
+	return objects, nil
+}
+
+// objectInfo builds the object information of a local file
+func (ls *LocalStorage) objectInfo(key string, stat os.FileInfo) ObjectInfo {
+	return ObjectInfo{
+		Key:          key,
+		Size:         stat.Size(),
+		ContentType:  ContentTypeForKey(key),
+		LastModified: stat.ModTime(),
+	}
+}
+
 // S3Storage implements StorageProvider for S3-compatible storage
 type S3Storage struct {
 	client       *s3.Client
@@ -107,30 +298,72 @@ func NewS3Storage(cfg *config.Config) (*S3Storage, error) {
 }
 
 func (s *S3Storage) Store(ctx context.Context, key string, data []byte) error {
+	return s.StoreWithACL(ctx, key, data, false)
+}
+
+// StoreWithACL stores an object, withholding the public-read ACL when private is set
+func (s *S3Storage) StoreWithACL(ctx context.Context, key string, data []byte, private bool) error {
+	return s.PutWithACL(ctx, key, bytes.NewReader(data), int64(len(data)), ContentTypeForKey(key), private)
+}
+
+// Put streams an object to S3 with public-read access
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	return s.PutWithACL(ctx, key, r, size, contentType, false)
+}
+
+// PutWithACL streams an object to S3, withholding the public-read ACL when private is set.
+// Objects of unknown size or larger than s3MultipartThreshold use a multipart upload,
+// so at most one part is held in memory.
+func (s *S3Storage) PutWithACL(ctx context.Context, key string, r io.Reader, size int64, contentType string, private bool) error {
 	logger.Info("Storing to S3",
 		zap.String("bucket", s.bucket),
 		zap.String("key", key),
-		zap.Int("size", len(data)))
+		zap.Int64("size", size),
+		zap.Bool("private", private))
 
-	contentType := "application/octet-stream"
-	ext := strings.ToLower(filepath.Ext(key))
-	switch ext {
-	case ".jpg", ".jpeg":
-		contentType = "image/jpeg"
-	case ".webp":
-		contentType = "image/webp"
-	case ".avif":
-		contentType = "image/avif"
-	}
-
-	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket:       aws.String(s.bucket),
-		Key:          aws.String(key),
-		Body:         bytes.NewReader(data),
-		ContentType:  aws.String(contentType),
-		ACL:          types.ObjectCannedACLPublicRead,
-		CacheControl: aws.String("public, max-age=31536000"), // Cache for one year
-	})
+	if contentType == "" {
+		contentType = ContentTypeForKey(key)
+	}
+
+	acl := types.ObjectCannedACLPublicRead
+	cacheControl := "public, max-age=31536000" // Cache for one year
+	if private {
+		acl = types.ObjectCannedACLPrivate
+		cacheControl = "private, no-store"
+	}
+
+	// Buffer small objects so the request body is seekable and can be signed
+	limit := int64(s3MultipartThreshold)
+	if size >= 0 && size < limit {
+		limit = size
+	}
+	head, err := io.ReadAll(io.LimitReader(r, limit))
+	if err != nil {
+		return fmt.Errorf("failed to read object data: %v", err)
+	}
+
+	if size >= 0 && int64(len(head)) < limit {
+		return fmt.Errorf("short read for %s: got %d of %d bytes", key, len(head), size)
+	}
+
+	if int64(len(head)) < s3MultipartThreshold {
+		_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:       aws.String(s.bucket),
+			Key:          aws.String(key),
+			Body:         bytes.NewReader(head),
+			ContentType:  aws.String(contentType),
+			ACL:          acl,
+			CacheControl: aws.String(cacheControl),
+		})
+	} else {
+		err = s.putMultipart(ctx, &s3.CreateMultipartUploadInput{
+			Bucket:       aws.String(s.bucket),
+			Key:          aws.String(key),
+			ContentType:  aws.String(contentType),
+			ACL:          acl,
+			CacheControl: aws.String(cacheControl),
+		}, io.MultiReader(bytes.NewReader(head), r))
+	}
 	if err != nil {
 		logger.Error("Failed to store object in S3",
 			zap.String("bucket", s.bucket),
@@ -151,6 +384,100 @@ func (s *S3Storage) Store(ctx context.Context, key string, data []byte) error {
 	return nil
 }
 
+// putMultipart uploads r in parts of s3PartSize, aborting the upload on failure
+func (s *S3Storage) putMultipart(ctx context.Context, input *s3.CreateMultipartUploadInput, r io.Reader) error {
+	upload, err := s.client.CreateMultipartUpload(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to start multipart upload: %v", err)
+	}
+
+	abort := func(cause error) error {
+		if _, err := s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   input.Bucket,
+			Key:      input.Key,
+			UploadId: upload.UploadId,
+		}); err != nil {
+			logger.Warn("Failed to abort multipart upload",
+				zap.String("key", aws.ToString(input.Key)),
+				zap.Error(err))
+		}
+		return cause
+	}
+
+	var parts []types.CompletedPart
+	buf := make([]byte, s3PartSize)
+	for partNumber := int32(1); ; partNumber++ {
+		n, readErr := io.ReadFull(r, buf)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return abort(fmt.Errorf("failed to read part %d: %v", partNumber, readErr))
+		}
+		if n == 0 && partNumber > 1 {
+			break
+		}
+
+		part, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:        input.Bucket,
+			Key:           input.Key,
+			UploadId:      upload.UploadId,
+			PartNumber:    aws.Int32(partNumber),
+			Body:          bytes.NewReader(buf[:n]),
+			ContentLength: aws.Int64(int64(n)),
+		})
+		if err != nil {
+			return abort(fmt.Errorf("failed to upload part %d: %v", partNumber, err))
+		}
+		parts = append(parts, types.CompletedPart{
+			ETag:       part.ETag,
+			PartNumber: aws.Int32(partNumber),
+		})
+
+		if readErr != nil {
+			break
+		}
+	}
+
+	if _, err := s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          input.Bucket,
+		Key:             input.Key,
+		UploadId:        upload.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	}); err != nil {
+		return abort(fmt.Errorf("failed to complete multipart upload: %v", err))
+	}
+
+	logger.Debug("Multipart upload completed",
+		zap.String("key", aws.ToString(input.Key)),
+		zap.Int("parts", len(parts)))
+	return nil
+}
+
+// SetObjectACL switches an existing object between public-read and private access
+func (s *S3Storage) SetObjectACL(ctx context.Context, key string, private bool) error {
+	acl := types.ObjectCannedACLPublicRead
+	if private {
+		acl = types.ObjectCannedACLPrivate
+	}
+
+	_, err := s.client.PutObjectAcl(ctx, &s3.PutObjectAclInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		ACL:    acl,
+	})
+	if err != nil {
+		logger.Error("Failed to update object ACL in S3",
+			zap.String("bucket", s.bucket),
+			zap.String("key", key),
+			zap.Bool("private", private),
+			zap.Error(err))
+		return fmt.Errorf("failed to update object ACL in S3: %v", err)
+	}
+
+	logger.Debug("Updated object ACL in S3",
+		zap.String("key", key),
+		zap.Bool("private", private))
+	return nil
+}
+
 func (s *S3Storage) Get(ctx context.Context, key string) ([]byte, error) {
 	logger.Debug("Getting object from S3",
 		zap.String("bucket", s.bucket),
@@ -206,6 +533,100 @@ func (s *S3Storage) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
+// Open returns a streaming reader for an S3 object
+func (s *S3Storage) Open(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		logger.Error("Failed to get object from S3",
+			zap.String("bucket", s.bucket),
+			zap.String("key", key),
+			zap.Error(err))
+		return nil, ObjectInfo{}, fmt.Errorf("failed to get object from S3: %v", err)
+	}
+
+	return result.Body, s3ObjectInfo(key, result.ContentLength, result.ContentType, result.LastModified, result.ETag), nil
+}
+
+// OpenRange returns a streaming reader for a byte range of an S3 object
+func (s *S3Storage) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length >= 0 {
+		byteRange = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	}
+
+	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(byteRange),
+	})
+	if err != nil {
+		logger.Error("Failed to get object range from S3",
+			zap.String("bucket", s.bucket),
+			zap.String("key", key),
+			zap.String("range", byteRange),
+			zap.Error(err))
+		return nil, fmt.Errorf("failed to get object range from S3: %v", err)
+	}
+	return result.Body, nil
+}
+
+// Stat returns information about an S3 object using a HEAD request
+func (s *S3Storage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	result, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to stat object in S3: %v", err)
+	}
+
+	return s3ObjectInfo(key, result.ContentLength, result.ContentType, result.LastModified, result.ETag), nil
+}
+
+// List returns all objects in S3 whose key starts with prefix
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects from S3: %v", err)
+		}
+
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			objects = append(objects, s3ObjectInfo(key, obj.Size, nil, obj.LastModified, obj.ETag))
+		}
+	}
+
+	return objects, nil
+}
+
+// s3ObjectInfo builds object information from the optional fields of an S3 response
+func s3ObjectInfo(key string, size *int64, contentType *string, lastModified *time.Time, etag *string) ObjectInfo {
+	info := ObjectInfo{
+		Key:         key,
+		Size:        aws.ToInt64(size),
+		ContentType: aws.ToString(contentType),
+		ETag:        strings.Trim(aws.ToString(etag), `"`),
+	}
+	if info.ContentType == "" {
+		info.ContentType = ContentTypeForKey(key)
+	}
+	if lastModified != nil {
+		info.LastModified = *lastModified
+	}
+	return info
+}
+
 // ListObjects lists objects in S3 with the given prefix
 func (s *S3Storage) ListObjects(ctx context.Context, prefix string) ([]S3Object, error) {
 	logger.Debug("Listing objects in S3",
@@ -57,6 +57,18 @@ func (usp *UserStoragePaths) GetAVIFPath(filename, orientation string) string {
 	return filepath.Join("users", usp.userID, orientation, "avif", filename+".avif")
 }
 
+// GetThumbnailPath returns the storage path for a WebP thumbnail of the given long-edge size
+func (usp *UserStoragePaths) GetThumbnailPath(filename, orientation string, size int) string {
+	name := fmt.Sprintf("%s_%d.webp", filename, size)
+	if usp.cfg.AuthType == config.AuthTypeAPIKey {
+		// Legacy path for API Key users
+		return filepath.Join(orientation, "thumb", name)
+	}
+
+	// Multi-tenant path for OIDC users
+	return filepath.Join("users", usp.userID, orientation, "thumb", name)
+}
+
 // GetGIFPath returns the storage path for GIF images
 func (usp *UserStoragePaths) GetGIFPath(filename string) string {
 	if usp.cfg.AuthType == config.AuthTypeAPIKey {
@@ -75,10 +87,16 @@ func (usp *UserStoragePaths) GetUserDirectories() []string {
 		return []string{
 			filepath.Join(usp.cfg.ImageBasePath, "original", "landscape"),
 			filepath.Join(usp.cfg.ImageBasePath, "original", "portrait"),
+			filepath.Join(usp.cfg.ImageBasePath, "original", "square"),
 			filepath.Join(usp.cfg.ImageBasePath, "landscape", "webp"),
 			filepath.Join(usp.cfg.ImageBasePath, "landscape", "avif"),
 			filepath.Join(usp.cfg.ImageBasePath, "portrait", "webp"),
 			filepath.Join(usp.cfg.ImageBasePath, "portrait", "avif"),
+			filepath.Join(usp.cfg.ImageBasePath, "square", "webp"),
+			filepath.Join(usp.cfg.ImageBasePath, "square", "avif"),
+			filepath.Join(usp.cfg.ImageBasePath, "landscape", "thumb"),
+			filepath.Join(usp.cfg.ImageBasePath, "portrait", "thumb"),
+			filepath.Join(usp.cfg.ImageBasePath, "square", "thumb"),
 			filepath.Join(usp.cfg.ImageBasePath, "gif"),
 		}
 	}
@@ -88,10 +106,16 @@ func (usp *UserStoragePaths) GetUserDirectories() []string {
 	return []string{
 		filepath.Join(userBasePath, "original", "landscape"),
 		filepath.Join(userBasePath, "original", "portrait"),
+		filepath.Join(userBasePath, "original", "square"),
 		filepath.Join(userBasePath, "landscape", "webp"),
 		filepath.Join(userBasePath, "landscape", "avif"),
 		filepath.Join(userBasePath, "portrait", "webp"),
 		filepath.Join(userBasePath, "portrait", "avif"),
+		filepath.Join(userBasePath, "square", "webp"),
+		filepath.Join(userBasePath, "square", "avif"),
+		filepath.Join(userBasePath, "landscape", "thumb"),
+		filepath.Join(userBasePath, "portrait", "thumb"),
+		filepath.Join(userBasePath, "square", "thumb"),
 		filepath.Join(userBasePath, "gif"),
 	}
 }
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Yuri-NagaSaki/ImageFlow/config"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils/logger"
+	"go.uber.org/zap"
+)
+
+// ThumbnailVariant returns the name under which a thumbnail of the given size is
+// recorded in metadata paths, sizes and URL maps
+func ThumbnailVariant(size int) string {
+	return fmt.Sprintf("thumb_%d", size)
+}
+
+// GenerateThumbnail produces a WebP thumbnail whose long edge is at most size pixels
+func GenerateThumbnail(data []byte, size int, cfg *config.Config) ([]byte, error) {
+	opts := TransformOptions{
+		Width:  size,
+		Height: size,
+		Fit:    FitContain,
+		Format: "webp",
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return TransformWithBimg(data, opts, cfg)
+}
+
+// DeleteThumbnails removes all thumbnails recorded for an image from storage
+func DeleteThumbnails(ctx context.Context, metadata *ImageMetadata) {
+	if metadata == nil {
+		return
+	}
+
+	for variant, key := range metadata.Paths.Thumbnails {
+		if err := Storage.Delete(ctx, key); err != nil {
+			logger.Error("Failed to delete thumbnail",
+				zap.String("image_id", metadata.ID),
+				zap.String("variant", variant),
+				zap.String("path", key),
+				zap.Error(err))
+		} else {
+			logger.Debug("Deleted thumbnail",
+				zap.String("path", key))
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/h2non/bimg"
+)
+
+// Fit modes for derived image variants
+const (
+	FitCover   = "cover"   // Fill the box and crop the overflow
+	FitContain = "contain" // Fit inside the box, preserving aspect ratio
+	FitFill    = "fill"    // Stretch to the exact box dimensions
+)
+
+// MaxTransformDimension caps the width and height of derived variants
+const MaxTransformDimension = 4096
+
+// transformFormat describes an output format supported by the transform pipeline
+type transformFormat struct {
+	imageType bimg.ImageType
+	extension string
+	mimeType  string
+}
+
+// transformFormats maps output format names to their encoding details
+var transformFormats = map[string]transformFormat{
+	"webp": {imageType: bimg.WEBP, extension: ".webp", mimeType: "image/webp"},
+	"avif": {imageType: bimg.AVIF, extension: ".avif", mimeType: "image/avif"},
+	"jpeg": {imageType: bimg.JPEG, extension: ".jpg", mimeType: "image/jpeg"},
+	"png":  {imageType: bimg.PNG, extension: ".png", mimeType: "image/png"},
+}
+
+// gravities maps gravity names to bimg gravity values
+var gravities = map[string]bimg.Gravity{
+	"centre": bimg.GravityCentre,
+	"center": bimg.GravityCentre,
+	"north":  bimg.GravityNorth,
+	"east":   bimg.GravityEast,
+	"south":  bimg.GravitySouth,
+	"west":   bimg.GravityWest,
+	"smart":  bimg.GravitySmart,
+}
+
+// TransformOptions describes a resized, cropped or re-encoded variant of an original image
+type TransformOptions struct {
+	Width   int    // Target width in pixels (0 = derived from height)
+	Height  int    // Target height in pixels (0 = derived from width)
+	Fit     string // cover, contain or fill
+	Gravity string // Crop anchor used by cover: centre, north, east, south, west or smart
+	Format  string // Output format: webp, avif, jpeg or png
+	Quality int    // Output quality (1-100, 0 = configured default)
+}
+
+// Validate normalizes the options and checks that they describe a valid transform
+func (o *TransformOptions) Validate() error {
+	o.Fit = strings.ToLower(o.Fit)
+	o.Gravity = strings.ToLower(o.Gravity)
+	o.Format = strings.ToLower(o.Format)
+
+	if o.Width < 0 || o.Height < 0 {
+		return fmt.Errorf("width and height must not be negative")
+	}
+	if o.Width > MaxTransformDimension || o.Height > MaxTransformDimension {
+		return fmt.Errorf("width and height must not exceed %d pixels", MaxTransformDimension)
+	}
+
+	if o.Fit == "" {
+		o.Fit = FitContain
+	}
+	switch o.Fit {
+	case FitCover, FitContain, FitFill:
+	default:
+		return fmt.Errorf("unsupported fit mode: %s", o.Fit)
+	}
+	if o.Fit == FitFill && (o.Width == 0 || o.Height == 0) {
+		return fmt.Errorf("fit mode fill requires both width and height")
+	}
+
+	if o.Gravity == "" {
+		o.Gravity = "centre"
+	}
+	if _, ok := gravities[o.Gravity]; !ok {
+		return fmt.Errorf("unsupported gravity: %s", o.Gravity)
+	}
+
+	if o.Format == "jpg" {
+		o.Format = "jpeg"
+	}
+	if _, ok := transformFormats[o.Format]; !ok {
+		return fmt.Errorf("unsupported output format: %s", o.Format)
+	}
+
+	if o.Quality < 0 || o.Quality > 100 {
+		return fmt.Errorf("quality must be between 1 and 100")
+	}
+
+	return nil
+}
+
+// MimeType returns the MIME type of the transform output
+func (o TransformOptions) MimeType() string {
+	return transformFormats[o.Format].mimeType
+}
+
+// Extension returns the file extension of the transform output
+func (o TransformOptions) Extension() string {
+	return transformFormats[o.Format].extension
+}
+
+// IsSupportedTransformFormat checks if a format name can be produced by the transform pipeline
+func IsSupportedTransformFormat(format string) bool {
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	_, ok := transformFormats[format]
+	return ok
+}
+
+// targetSize calculates the output dimensions for a source image of the given size.
+// Contain never enlarges the source, cover and fill honour the requested box exactly.
+func (o TransformOptions) targetSize(srcWidth, srcHeight int) (int, int) {
+	if srcWidth <= 0 || srcHeight <= 0 {
+		return o.Width, o.Height
+	}
+
+	if o.Width == 0 && o.Height == 0 {
+		return srcWidth, srcHeight
+	}
+
+	if o.Fit == FitContain || o.Width == 0 || o.Height == 0 {
+		scale := math.Inf(1)
+		if o.Width > 0 {
+			scale = float64(o.Width) / float64(srcWidth)
+		}
+		if o.Height > 0 {
+			scale = math.Min(scale, float64(o.Height)/float64(srcHeight))
+		}
+		if scale > 1 {
+			scale = 1
+		}
+		width := int(math.Round(float64(srcWidth) * scale))
+		height := int(math.Round(float64(srcHeight) * scale))
+		return max(width, 1), max(height, 1)
+	}
+
+	return o.Width, o.Height
+}
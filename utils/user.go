@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/Yuri-NagaSaki/ImageFlow/config"
@@ -19,6 +21,7 @@ type User struct {
 	Name      string    `json:"name"`       // User display name
 	Picture   string    `json:"picture"`    // User avatar URL
 	Provider  string    `json:"provider"`   // OIDC provider (e.g., "google", "auth0")
+	Handle    string    `json:"handle"`     // Public handle used in per-user random image URLs
 	CreatedAt time.Time `json:"created_at"` // When the user was first created
 	UpdatedAt time.Time `json:"updated_at"` // When the user info was last updated
 	LastLogin time.Time `json:"last_login"` // When the user last logged in
@@ -33,6 +36,24 @@ type UserStore interface {
 	UpdateLastLogin(ctx context.Context, userID string) error
 	ListUsers(ctx context.Context) ([]*User, error)
 	DeactivateUser(ctx context.Context, userID string) error
+	SetHandle(ctx context.Context, userID, handle string) error
+	GetUserByHandle(ctx context.Context, handle string) (*User, error)
+}
+
+// ErrHandleTaken is returned when a handle already belongs to another user
+var ErrHandleTaken = fmt.Errorf("handle is already taken")
+
+// handlePattern restricts handles to short lowercase URL-safe names
+var handlePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{2,31}$`)
+
+// NormalizeHandle lowercases a handle and checks that it is 3-32 characters of
+// letters, digits, hyphens and underscores, starting with a letter or digit
+func NormalizeHandle(handle string) (string, error) {
+	handle = strings.ToLower(strings.TrimSpace(handle))
+	if !handlePattern.MatchString(handle) {
+		return "", fmt.Errorf("handle must be 3-32 characters of letters, digits, '-' or '_', starting with a letter or digit")
+	}
+	return handle, nil
 }
 
 // RedisUserStore implements user storage using Redis
@@ -61,6 +82,11 @@ func (r *RedisUserStore) userKey(userID string) string {
 	return r.keyPrefix + userID
 }
 
+// handleKey returns the Redis key mapping a handle to its user ID
+func (r *RedisUserStore) handleKey(handle string) string {
+	return RedisPrefix + "handle:" + handle
+}
+
 // CreateUser creates a new user in Redis
 func (r *RedisUserStore) CreateUser(ctx context.Context, user *User) error {
 	user.CreatedAt = time.Now()
@@ -192,6 +218,58 @@ func (r *RedisUserStore) DeactivateUser(ctx context.Context, userID string) erro
 	return nil
 }
 
+// SetHandle claims a handle for a user, releasing the user's previous handle
+func (r *RedisUserStore) SetHandle(ctx context.Context, userID, handle string) error {
+	user, err := r.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.Handle == handle {
+		return nil
+	}
+
+	claimed, err := r.client.SetNX(ctx, r.handleKey(handle), userID, 0).Result()
+	if err != nil {
+		return fmt.Errorf("failed to claim handle: %v", err)
+	}
+	if !claimed {
+		return ErrHandleTaken
+	}
+
+	previous := user.Handle
+	user.Handle = handle
+	if err := r.UpdateUser(ctx, user); err != nil {
+		r.client.Del(ctx, r.handleKey(handle))
+		return err
+	}
+
+	if previous != "" {
+		if err := r.client.Del(ctx, r.handleKey(previous)).Err(); err != nil {
+			logger.Warn("Failed to release previous handle",
+				zap.String("user_id", userID),
+				zap.String("handle", previous),
+				zap.Error(err))
+		}
+	}
+
+	logger.Info("User handle updated",
+		zap.String("user_id", userID),
+		zap.String("handle", handle))
+	return nil
+}
+
+// GetUserByHandle retrieves the user owning a handle
+func (r *RedisUserStore) GetUserByHandle(ctx context.Context, handle string) (*User, error) {
+	userID, err := r.client.Get(ctx, r.handleKey(handle)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, fmt.Errorf("handle not found: %s", handle)
+		}
+		return nil, fmt.Errorf("failed to look up handle: %v", err)
+	}
+	return r.GetUser(ctx, userID)
+}
+
 // Global user store instance
 var UserManager UserStore
 
@@ -0,0 +1,265 @@
+package utils
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Yuri-NagaSaki/ImageFlow/config"
+	"github.com/Yuri-NagaSaki/ImageFlow/utils/logger"
+	"go.uber.org/zap"
+)
+
+// variantEntry tracks a single cached variant in the LRU list
+type variantEntry struct {
+	key     string
+	imageID string
+	size    int64
+}
+
+// VariantCache keeps transform results in storage and evicts the least recently
+// used ones once the configured size cap is exceeded
+type VariantCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	used     int64
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+// Global variant cache instance
+var Variants *VariantCache
+
+// InitVariantCache creates the variant cache and rebuilds its index from stored metadata
+func InitVariantCache(cfg *config.Config) error {
+	Variants = &VariantCache{
+		maxBytes: int64(cfg.VariantCacheMaxMB) * 1024 * 1024,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+
+	if !Variants.Enabled() {
+		logger.Info("Variant cache disabled")
+		return nil
+	}
+
+	allMetadata, err := MetadataManager.GetAllMetadata(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to load metadata for variant cache: %v", err)
+	}
+
+	// Access times are not persisted, so older uploads are treated as least recently used
+	sort.Slice(allMetadata, func(i, j int) bool {
+		return allMetadata[i].UploadTime.After(allMetadata[j].UploadTime)
+	})
+
+	Variants.mu.Lock()
+	for _, metadata := range allMetadata {
+		for key, size := range metadata.Variants {
+			Variants.add(key, metadata.ID, size)
+		}
+	}
+	victims := Variants.evictLocked()
+	Variants.mu.Unlock()
+
+	Variants.removeVariants(context.Background(), victims)
+
+	logger.Info("Variant cache initialized",
+		zap.Int("entries", Variants.order.Len()),
+		zap.Int64("used_bytes", Variants.used),
+		zap.Int64("max_bytes", Variants.maxBytes))
+	return nil
+}
+
+// VariantKey returns the deterministic storage key for an image transformed with the given options
+func VariantKey(id string, opts TransformOptions) string {
+	params := fmt.Sprintf("%s|%d|%d|%s|%s|%s|%d",
+		id, opts.Width, opts.Height, opts.Fit, opts.Gravity, opts.Format, opts.Quality)
+	sum := sha256.Sum256([]byte(params))
+	return fmt.Sprintf("variants/%s/%s%s", id, hex.EncodeToString(sum[:16]), opts.Extension())
+}
+
+// Enabled reports whether variants should be cached at all
+func (vc *VariantCache) Enabled() bool {
+	return vc != nil && vc.maxBytes > 0
+}
+
+// Get returns a cached variant recorded in the image metadata, if it is still available
+func (vc *VariantCache) Get(ctx context.Context, metadata *ImageMetadata, key string) ([]byte, bool) {
+	if !vc.Enabled() {
+		return nil, false
+	}
+	if _, ok := metadata.Variants[key]; !ok {
+		return nil, false
+	}
+
+	data, err := Storage.Get(ctx, key)
+	if err != nil {
+		// The object vanished from storage; forget it so it gets regenerated
+		logger.Warn("Cached variant missing from storage",
+			zap.String("key", key),
+			zap.Error(err))
+		vc.mu.Lock()
+		vc.remove(key)
+		vc.mu.Unlock()
+		vc.forgetVariant(ctx, metadata.ID, key)
+		return nil, false
+	}
+
+	vc.mu.Lock()
+	if elem, ok := vc.entries[key]; ok {
+		vc.order.MoveToFront(elem)
+	} else {
+		vc.add(key, metadata.ID, int64(len(data)))
+	}
+	vc.mu.Unlock()
+
+	return data, true
+}
+
+// Put stores a freshly generated variant, records it on the parent image and
+// evicts old variants if the cache grew beyond its limit
+func (vc *VariantCache) Put(ctx context.Context, imageID, key string, data []byte) error {
+	if !vc.Enabled() {
+		return nil
+	}
+	size := int64(len(data))
+	if size > vc.maxBytes {
+		return nil
+	}
+
+	if err := Storage.Store(ctx, key, data); err != nil {
+		return fmt.Errorf("failed to store variant %s: %v", key, err)
+	}
+
+	// Only the variant records are written, so concurrent edits and deletions of the image win
+	err := MetadataManager.UpdateVariants(ctx, imageID, func(variants map[string]int64) {
+		variants[key] = size
+	})
+	if err != nil {
+		// Without a metadata record the object could never be found again
+		if delErr := Storage.Delete(ctx, key); delErr != nil {
+			logger.Warn("Failed to delete unrecorded variant",
+				zap.String("key", key),
+				zap.Error(delErr))
+		}
+		return fmt.Errorf("failed to record variant %s: %v", key, err)
+	}
+
+	vc.mu.Lock()
+	vc.add(key, imageID, size)
+	victims := vc.evictLocked()
+	vc.mu.Unlock()
+
+	vc.removeVariants(ctx, victims)
+	return nil
+}
+
+// Purge deletes every cached variant belonging to an image. It is called when the
+// image itself is removed, so the parent metadata is not updated.
+func (vc *VariantCache) Purge(ctx context.Context, metadata *ImageMetadata) {
+	if vc == nil || metadata == nil {
+		return
+	}
+
+	keys := make(map[string]struct{}, len(metadata.Variants))
+	for key := range metadata.Variants {
+		keys[key] = struct{}{}
+	}
+
+	vc.mu.Lock()
+	for key, elem := range vc.entries {
+		if elem.Value.(*variantEntry).imageID == metadata.ID {
+			keys[key] = struct{}{}
+		}
+	}
+	for key := range keys {
+		vc.remove(key)
+	}
+	vc.mu.Unlock()
+
+	for key := range keys {
+		if err := Storage.Delete(ctx, key); err != nil {
+			logger.Warn("Failed to delete cached variant",
+				zap.String("image_id", metadata.ID),
+				zap.String("key", key),
+				zap.Error(err))
+		}
+	}
+
+	if len(keys) > 0 {
+		logger.Debug("Purged cached variants",
+			zap.String("image_id", metadata.ID),
+			zap.Int("count", len(keys)))
+	}
+}
+
+// add inserts or refreshes an entry; the caller must hold vc.mu
+func (vc *VariantCache) add(key, imageID string, size int64) {
+	if elem, ok := vc.entries[key]; ok {
+		entry := elem.Value.(*variantEntry)
+		vc.used += size - entry.size
+		entry.size = size
+		vc.order.MoveToFront(elem)
+		return
+	}
+	vc.entries[key] = vc.order.PushFront(&variantEntry{key: key, imageID: imageID, size: size})
+	vc.used += size
+}
+
+// remove drops an entry from the index; the caller must hold vc.mu
+func (vc *VariantCache) remove(key string) {
+	elem, ok := vc.entries[key]
+	if !ok {
+		return
+	}
+	vc.used -= elem.Value.(*variantEntry).size
+	vc.order.Remove(elem)
+	delete(vc.entries, key)
+}
+
+// evictLocked pops least recently used entries until the cache fits its limit;
+// the caller must hold vc.mu
+func (vc *VariantCache) evictLocked() []*variantEntry {
+	var victims []*variantEntry
+	for vc.used > vc.maxBytes && vc.order.Len() > 0 {
+		entry := vc.order.Back().Value.(*variantEntry)
+		vc.remove(entry.key)
+		victims = append(victims, entry)
+	}
+	return victims
+}
+
+// removeVariants deletes evicted variants from storage and from their parent metadata
+func (vc *VariantCache) removeVariants(ctx context.Context, victims []*variantEntry) {
+	for _, entry := range victims {
+		if err := Storage.Delete(ctx, entry.key); err != nil {
+			logger.Warn("Failed to delete evicted variant",
+				zap.String("key", entry.key),
+				zap.Error(err))
+		}
+		vc.forgetVariant(ctx, entry.imageID, entry.key)
+
+		logger.Debug("Evicted cached variant",
+			zap.String("image_id", entry.imageID),
+			zap.String("key", entry.key),
+			zap.Int64("size", entry.size))
+	}
+}
+
+// forgetVariant removes a variant record from its parent image metadata
+func (vc *VariantCache) forgetVariant(ctx context.Context, imageID, key string) {
+	err := MetadataManager.UpdateVariants(ctx, imageID, func(variants map[string]int64) {
+		delete(variants, key)
+	})
+	if err != nil {
+		// Also reached when the image itself has been deleted meanwhile
+		logger.Debug("Failed to update variant records",
+			zap.String("image_id", imageID),
+			zap.Error(err))
+	}
+}
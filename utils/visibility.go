@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/Yuri-NagaSaki/ImageFlow/utils/logger"
+	"go.uber.org/zap"
+)
+
+// Image visibility levels
+const (
+	VisibilityPublic   = "public"   // Listed in random selection and reachable by URL
+	VisibilityUnlisted = "unlisted" // Reachable by URL but never picked at random
+	VisibilityPrivate  = "private"  // Served only to the owner or through signed URLs
+)
+
+// NormalizeVisibility validates a visibility value, treating an empty value as public
+func NormalizeVisibility(visibility string) (string, error) {
+	switch v := strings.ToLower(strings.TrimSpace(visibility)); v {
+	case "":
+		return VisibilityPublic, nil
+	case VisibilityPublic, VisibilityUnlisted, VisibilityPrivate:
+		return v, nil
+	default:
+		return "", fmt.Errorf("invalid visibility: %s", visibility)
+	}
+}
+
+// IsPrivate reports whether the image may only be served to its owner
+func (m *ImageMetadata) IsPrivate() bool {
+	return m.Visibility == VisibilityPrivate
+}
+
+// IsListed reports whether the image may be picked by random selection.
+// Images stored before visibility existed have no value and are public.
+func (m *ImageMetadata) IsListed() bool {
+	return m.Visibility == "" || m.Visibility == VisibilityPublic
+}
+
+// StoredKeys returns the storage keys of every rendition kept for the image
+func (m *ImageMetadata) StoredKeys() []string {
+	seen := make(map[string]bool)
+	var keys []string
+	add := func(key string) {
+		if key != "" && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	add(m.Paths.Original)
+	add(m.Paths.WebP)
+	add(m.Paths.AVIF)
+	for _, key := range m.Paths.Thumbnails {
+		add(key)
+	}
+	for key := range m.Variants {
+		add(key)
+	}
+	return keys
+}
+
+// StoreImageObject stores an image rendition, keeping it out of public reach when private is set
+func StoreImageObject(ctx context.Context, key string, data []byte, private bool) error {
+	return PutImageObject(ctx, key, bytes.NewReader(data), int64(len(data)), ContentTypeForKey(key), private)
+}
+
+// PutImageObject streams an image rendition to storage, keeping it out of public reach when private is set
+func PutImageObject(ctx context.Context, key string, r io.Reader, size int64, contentType string, private bool) error {
+	if s3Storage, ok := Storage.(*S3Storage); ok {
+		return s3Storage.PutWithACL(ctx, key, r, size, contentType, private)
+	}
+	return Storage.Put(ctx, key, r, size, contentType)
+}
+
+// ApplyVisibility updates the access control of all stored renditions after the
+// visibility of an image changed. Local files are guarded at serve time instead.
+func ApplyVisibility(ctx context.Context, metadata *ImageMetadata) error {
+	s3Storage, ok := Storage.(*S3Storage)
+	if !ok {
+		return nil
+	}
+
+	var failed int
+	for _, key := range metadata.StoredKeys() {
+		if err := s3Storage.SetObjectACL(ctx, key, metadata.IsPrivate()); err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to update access for %d objects of image %s", failed, metadata.ID)
+	}
+
+	logger.Debug("Applied image visibility to stored objects",
+		zap.String("image_id", metadata.ID),
+		zap.String("visibility", metadata.Visibility))
+	return nil
+}
+
+// ImageIDFromKey extracts the image ID from a storage key of any rendition.
+// Image IDs have the form 20060102_150405_1234; thumbnails append _<size>.
+func ImageIDFromKey(key string) string {
+	key = strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(key, "\\", "/")), "/")
+	segments := strings.Split(key, "/")
+	if len(segments) >= 3 && segments[0] == "variants" {
+		return segments[1]
+	}
+
+	base := path.Base(key)
+	base = strings.TrimSuffix(base, path.Ext(base))
+	parts := strings.SplitN(base, "_", 4)
+	if len(parts) > 3 {
+		parts = parts[:3]
+	}
+	return strings.Join(parts, "_")
+}